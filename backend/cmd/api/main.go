@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 
 	"passkeys/internal/auth"
 	"passkeys/internal/db"
 	"passkeys/internal/handlers"
+	"passkeys/internal/mail"
 	"passkeys/internal/middleware"
+	"passkeys/internal/oidc"
+	"passkeys/internal/throttle"
+	"passkeys/internal/webauthn"
 )
 
 func main() {
@@ -43,20 +50,158 @@ func main() {
 		}
 	}
 
+	// Ключ шифрования секретов 2FA; по умолчанию выводится из JWT_SECRET.
+	mfaKeySource := []byte(secret)
+	if k := os.Getenv("MFA_ENCRYPTION_KEY"); k != "" {
+		mfaKeySource = []byte(k)
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	origins := []string{"http://localhost:5173", "http://localhost:8080"}
+	if o := os.Getenv("WEBAUTHN_ORIGINS"); o != "" {
+		origins = strings.Split(o, ",")
+	}
+
+	limiter := throttle.New(pool)
+	if n := os.Getenv("LOGIN_LOCKOUT_THRESHOLD"); n != "" {
+		if threshold, err := strconv.Atoi(n); err == nil && threshold > 0 {
+			limiter.LockoutThreshold = threshold
+		}
+	}
+	if m := os.Getenv("LOGIN_LOCKOUT_MINUTES"); m != "" {
+		if minutes, err := strconv.Atoi(m); err == nil && minutes > 0 {
+			limiter.LockoutDuration = time.Duration(minutes) * time.Minute
+		}
+	}
+	go runPeriodically(ctx, "throttle cleanup", time.Hour, limiter.Cleanup)
+
+	// Ключи подписи JWT хранятся в БД зашифрованными; при первом запуске создаётся ключ JWT_SIGNING_ALG.
+	signingAlg := os.Getenv("JWT_SIGNING_ALG")
+	if signingAlg == "" {
+		signingAlg = auth.AlgEdDSA
+	}
+	keySealSource := []byte(secret)
+	if k := os.Getenv("JWT_KEY_ENCRYPTION_KEY"); k != "" {
+		keySealSource = []byte(k)
+	}
+	keyStore := auth.NewKeyStore(pool, auth.SigningKeySealKey(keySealSource), accessLifetime)
+	if err := keyStore.EnsureSigningKey(ctx, signingAlg); err != nil {
+		log.Fatalf("signing key init failed: %v", err)
+	}
+	if err := keyStore.Reload(ctx); err != nil {
+		log.Fatalf("signing key load failed: %v", err)
+	}
+	go runPeriodically(ctx, "signing key reload", time.Minute, keyStore.Reload)
+	go runPeriodically(ctx, "signing key purge", time.Hour, keyStore.PurgeRetired)
+
+	// Отзыв сессии (выход, завершение на другом устройстве) сразу отключает её access-токены.
+	sessionStore := auth.NewSessionStore(pool, accessLifetime)
+	requireAuth := middleware.AuthMiddleware(keyStore.Keys, sessionStore, nil)
+	// Данные хранилища доступны и персональным токенам (в пределах их scopes); /auth — только сессиям.
+	requireAuthOrToken := middleware.AuthMiddleware(keyStore.Keys, sessionStore, &auth.PersonalTokenStore{DB: pool})
+
+	deletionGrace := 30 * 24 * time.Hour
+	if d := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); d != "" {
+		if days, err := strconv.Atoi(d); err == nil && days >= 0 {
+			deletionGrace = time.Duration(days) * 24 * time.Hour
+		}
+	}
+
+	trashRetention := 30 * 24 * time.Hour
+	if d := os.Getenv("TRASH_RETENTION_DAYS"); d != "" {
+		if days, err := strconv.Atoi(d); err == nil && days >= 0 {
+			trashRetention = time.Duration(days) * 24 * time.Hour
+		}
+	}
+
+	// Параметры Argon2id; при их изменении хэши пересчитываются при следующем входе.
+	hasher := auth.NewArgon2idHasher()
+	if m := os.Getenv("PASSWORD_HASH_MEMORY_KIB"); m != "" {
+		if kib, err := strconv.Atoi(m); err == nil && kib >= 8*1024 {
+			hasher.Memory = uint32(kib)
+		}
+	}
+	if t := os.Getenv("PASSWORD_HASH_ITERATIONS"); t != "" {
+		if n, err := strconv.Atoi(t); err == nil && n > 0 {
+			hasher.Iterations = uint32(n)
+		}
+	}
+	if p := os.Getenv("PASSWORD_HASH_PARALLELISM"); p != "" {
+		if n, err := strconv.Atoi(p); err == nil && n > 0 && n < 256 {
+			hasher.Parallelism = uint8(n)
+		}
+	}
+
+	// Страница фронтенда, где пользователь подтверждает вход headless-клиента.
+	deviceVerificationURI := os.Getenv("DEVICE_VERIFICATION_URI")
+	if deviceVerificationURI == "" {
+		deviceVerificationURI = origins[0] + "/device"
+	}
+
+	// Вход через SSO включается заданием OIDC_ISSUER; метаданные провайдера загружаются при первом входе.
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = origins[0] + "/oidc/callback"
+		}
+		oidcProvider = oidc.New(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		})
+	}
+
+	mailer, err := newMailer()
+	if err != nil {
+		log.Fatalf("mailer init failed: %v", err)
+	}
+
 	authHandler := &handlers.AuthHandler{
-		DB:                  pool,
-		Secret:              []byte(secret),
+		DB:                   pool,
+		Secret:               []byte(secret),
+		Keys:                 keyStore.Keys,
 		AccessTokenLifetime:  accessLifetime,
 		RefreshTokenLifetime: refreshLifetime,
+		MFAKey:               auth.DeriveKey(mfaKeySource, "passkeys/mfa"),
+		WebAuthn: webauthn.Config{
+			RPID:    rpID,
+			RPName:  "Passkeys",
+			Origins: origins,
+		},
+		Hasher:                     hasher,
+		Limiter:                    limiter,
+		Mailer:                     mailer,
+		AccountDeletionGracePeriod: deletionGrace,
+		Sessions:                   sessionStore,
+		DeviceVerificationURI:      deviceVerificationURI,
+		OIDC:                       oidcProvider,
+		OIDCLinkByEmail:            os.Getenv("OIDC_LINK_BY_EMAIL") == "true",
 	}
+	go runPeriodically(ctx, "refresh token purge", time.Hour, authHandler.PurgeExpiredRefreshTokens)
+	go runPeriodically(ctx, "account purge", time.Hour, authHandler.PurgeDeletedAccounts)
+	go runPeriodically(ctx, "device code purge", time.Hour, authHandler.PurgeExpiredDeviceCodes)
+
 	accountHandler := &handlers.AccountHandler{DB: pool}
 	noteHandler := &handlers.NoteHandler{DB: pool}
+	itemHandler := &handlers.ItemHandler{DB: pool, TrashRetention: trashRetention}
+	go runPeriodically(ctx, "trash purge", time.Hour, itemHandler.PurgeTrash)
+	folderHandler := &handlers.FolderHandler{DB: pool}
 
 	router := chi.NewRouter()
+	// За обратным прокси адрес клиента берётся из X-Forwarded-For / X-Real-IP.
+	// Без прокси заголовкам доверять нельзя: их подделка обходит ограничение по IP.
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		router.Use(chimiddleware.RealIP)
+	}
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Device-Name"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
@@ -65,15 +210,137 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 	})
 
+	router.Get("/.well-known/jwks.json", authHandler.JWKS)
+
 	router.Route("/auth", func(r chi.Router) {
+		r.Post("/prelogin", authHandler.Prelogin)
 		r.Post("/register", authHandler.Register)
 		r.Post("/login", authHandler.Login)
+		r.Post("/login/mfa", authHandler.LoginMFA)
+		r.Post("/login/mfa/webauthn", authHandler.WebAuthnMFABegin)
+		r.Post("/passkey/begin", authHandler.PasskeyBegin)
+		r.Post("/passkey/finish", authHandler.PasskeyFinish)
 		r.Post("/refresh", authHandler.Refresh)
-		r.With(middleware.AuthMiddleware([]byte(secret))).Post("/password", authHandler.ChangePassword)
+		r.With(requireAuth).Post("/password", authHandler.ChangePassword)
+		r.With(requireAuth).Post("/vault/rekey", authHandler.RekeyVault)
+		r.With(requireAuth).Post("/logout", authHandler.Logout)
+		r.With(requireAuth).Delete("/account", authHandler.DeleteAccount)
+		r.Post("/account/restore", authHandler.RestoreAccount)
+
+		r.Route("/oidc", func(r chi.Router) {
+			r.Post("/begin", authHandler.OIDCBegin)
+			r.Post("/finish", authHandler.OIDCFinish)
+			r.With(requireAuth).Post("/link/begin", authHandler.OIDCLinkBegin)
+			r.With(requireAuth).Post("/link/finish", authHandler.OIDCLinkFinish)
+			r.With(requireAuth).Get("/identities", authHandler.ListOIDCIdentities)
+			r.With(requireAuth).Delete("/identities/{id}", authHandler.UnlinkOIDCIdentity)
+		})
+
+		r.Route("/device", func(r chi.Router) {
+			r.Post("/code", authHandler.DeviceCode)
+			r.Post("/token", authHandler.DeviceToken)
+			r.With(requireAuth).Post("/lookup", authHandler.DeviceLookup)
+			r.With(requireAuth).Post("/approve", authHandler.ApproveDevice)
+			r.With(requireAuth).Post("/deny", authHandler.DenyDevice)
+		})
+
+		r.With(requireAuth).Get("/keys", authHandler.GetKeyPair)
+		r.With(requireAuth).Put("/keys", authHandler.SetKeyPair)
+
+		r.Route("/email", func(r chi.Router) {
+			r.Use(requireAuth)
+			r.Post("/", authHandler.ChangeEmail)
+			r.Post("/confirm", authHandler.ConfirmEmailChange)
+			r.Post("/verify", authHandler.VerifyEmail)
+			r.Post("/verify/resend", authHandler.ResendEmailVerification)
+		})
+
+		r.Route("/tokens", func(r chi.Router) {
+			r.Use(requireAuth)
+			r.Get("/", authHandler.ListTokens)
+			r.Post("/", authHandler.CreateToken)
+			r.Delete("/{id}", authHandler.RevokeToken)
+		})
+
+		r.Route("/totp", func(r chi.Router) {
+			r.Use(requireAuth)
+			r.Get("/", authHandler.TOTPStatus)
+			r.Post("/setup", authHandler.TOTPSetup)
+			r.Post("/confirm", authHandler.TOTPConfirm)
+			r.Post("/disable", authHandler.TOTPDisable)
+		})
+
+		r.Route("/sessions", func(r chi.Router) {
+			r.Use(requireAuth)
+			r.Get("/", authHandler.ListSessions)
+			r.Post("/revoke-others", authHandler.RevokeOtherSessions)
+			r.Delete("/{id}", authHandler.RevokeSession)
+		})
+
+		r.Route("/recovery-codes", func(r chi.Router) {
+			r.Use(requireAuth)
+			r.Get("/", authHandler.RecoveryCodesStatus)
+			r.Post("/", authHandler.RegenerateRecoveryCodes)
+		})
+
+		r.Route("/webauthn", func(r chi.Router) {
+			r.Use(requireAuth)
+			r.Post("/register/begin", authHandler.WebAuthnRegisterBegin)
+			r.Post("/register/finish", authHandler.WebAuthnRegisterFinish)
+			r.Get("/credentials", authHandler.WebAuthnCredentials)
+			r.Delete("/credentials/{id}", authHandler.WebAuthnDeleteCredential)
+		})
+	})
+
+	router.Route("/admin", func(r chi.Router) {
+		r.Use(requireAuth, middleware.RequireAdmin(&auth.RoleStore{DB: pool}))
+		r.Get("/users", authHandler.AdminListUsers)
+		r.Get("/users/{id}", authHandler.AdminGetUser)
+		r.Post("/users/{id}/disable", authHandler.AdminDisableUser)
+		r.Post("/users/{id}/enable", authHandler.AdminEnableUser)
+		r.Post("/users/{id}/logout", authHandler.AdminLogoutUser)
+	})
+
+	router.Route("/emergency-access", func(r chi.Router) {
+		r.Use(requireAuth)
+		r.Get("/", authHandler.ListEmergencyAccess)
+		r.Post("/", authHandler.InviteEmergencyContact)
+		r.Delete("/{id}", authHandler.DeleteEmergencyAccess)
+		r.Post("/{id}/accept", authHandler.AcceptEmergencyInvite)
+		r.Post("/{id}/confirm", authHandler.ConfirmEmergencyContact)
+		r.Post("/{id}/initiate", authHandler.InitiateEmergencyAccess)
+		r.Post("/{id}/approve", authHandler.ApproveEmergencyAccess)
+		r.Post("/{id}/reject", authHandler.RejectEmergencyAccess)
+		r.Get("/{id}/vault", authHandler.EmergencyVault)
+	})
+
+	router.Route("/items", func(r chi.Router) {
+		r.Use(requireAuthOrToken, middleware.RequireScope("items"))
+		r.Get("/", itemHandler.List)
+		r.Post("/", itemHandler.Create)
+		r.Get("/{id}", itemHandler.Get)
+		r.Put("/{id}", itemHandler.Update)
+		r.Delete("/{id}", itemHandler.Delete)
+	})
+
+	router.Route("/trash", func(r chi.Router) {
+		r.Use(requireAuthOrToken, middleware.RequireScope("items"))
+		r.Get("/", itemHandler.ListTrash)
+		r.Delete("/", itemHandler.EmptyTrash)
+		r.Post("/{id}/restore", itemHandler.RestoreItem)
+		r.Delete("/{id}", itemHandler.PurgeItem)
+	})
+
+	router.Route("/folders", func(r chi.Router) {
+		r.Use(requireAuthOrToken, middleware.RequireScope("folders"))
+		r.Get("/", folderHandler.List)
+		r.Post("/", folderHandler.Create)
+		r.Put("/{id}", folderHandler.Update)
+		r.Delete("/{id}", folderHandler.Delete)
 	})
 
 	router.Route("/accounts", func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware([]byte(secret)))
+		r.Use(requireAuthOrToken, middleware.RequireScope("accounts"))
 		r.Get("/", accountHandler.List)
 		r.Post("/", accountHandler.Create)
 		r.Put("/{id}", accountHandler.Update)
@@ -81,7 +348,7 @@ func main() {
 	})
 
 	router.Route("/notes", func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware([]byte(secret)))
+		r.Use(requireAuthOrToken, middleware.RequireScope("notes"))
 		r.Get("/", noteHandler.List)
 		r.Post("/", noteHandler.Create)
 		r.Put("/{id}", noteHandler.Update)
@@ -104,3 +371,60 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// newMailer выбирает доставку писем по MAIL_DRIVER: log (по умолчанию), file или smtp.
+func newMailer() (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Passkeys <no-reply@localhost>"
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "log":
+		return mail.LogMailer{}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return mail.FileMailer{Dir: dir, From: from}, nil
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for MAIL_DRIVER=smtp")
+		}
+		port := 587
+		if p := os.Getenv("SMTP_PORT"); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+			}
+			port = n
+		}
+		return mail.SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// runPeriodically выполняет фоновую задачу сразу и затем каждые interval.
+func runPeriodically(ctx context.Context, name string, interval time.Duration, job func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := job(ctx); err != nil {
+			log.Printf("[%s] failed: %v", name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
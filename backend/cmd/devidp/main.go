@@ -0,0 +1,36 @@
+// Команда devidp запускает локальный OpenID-провайдер для проверки входа через OIDC без
+// настоящего IdP. Форма входа не показывается: провайдер сразу входит пользователем -email
+// (или адресом из параметра login_hint) и перенаправляет обратно с кодом.
+//
+//	devidp [-addr :9000] [-issuer http://localhost:9000] [-client-id passkeys] [-client-secret dev-secret]
+//
+// Не для продакшена: ключи подписи живут в памяти и меняются при каждом запуске.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"passkeys/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL as seen by the API and the browser")
+	clientID := flag.String("client-id", "passkeys", "client id")
+	clientSecret := flag.String("client-secret", "dev-secret", "client secret (empty for a public client)")
+	email := flag.String("email", "user@example.com", "email of the signed-in user")
+	subject := flag.String("subject", "dev-user", "subject of the signed-in user")
+	flag.Parse()
+
+	provider, err := oidctest.New(*clientID, *clientSecret)
+	if err != nil {
+		log.Fatalf("provider init failed: %v", err)
+	}
+	provider.Issuer = *issuer
+	provider.User = oidctest.User{Subject: *subject, Email: *email, EmailVerified: true, Name: "Dev User"}
+
+	log.Printf("dev OIDC provider %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
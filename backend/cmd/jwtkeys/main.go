@@ -0,0 +1,142 @@
+// Команда jwtkeys управляет ключами подписи JWT в таблице signing_keys.
+//
+//	jwtkeys list
+//	jwtkeys rotate [-alg EdDSA|ES256] [-delay 10m]
+//	jwtkeys delete <kid>
+//
+// Читает те же DATABASE_URL, JWT_SECRET и JWT_KEY_ENCRYPTION_KEY, что и API.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"passkeys/internal/auth"
+	"passkeys/internal/db"
+)
+
+// errUsage — неверные аргументы командной строки.
+var errUsage = errors.New("usage: jwtkeys list | rotate [-alg EdDSA|ES256] [-delay 10m] | delete <kid>")
+
+// keyStore — операции auth.KeyStore, которые нужны команде.
+type keyStore interface {
+	List(ctx context.Context) ([]*auth.SigningKey, error)
+	Rotate(ctx context.Context, algorithm string, publishDelay time.Duration) (*auth.SigningKey, error)
+	Delete(ctx context.Context, kid string) error
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx := context.Background()
+	pool, err := db.NewPool(ctx)
+	if err != nil {
+		log.Fatalf("db init failed: %v", err)
+	}
+	defer pool.Close()
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Fatal("JWT_SECRET is required")
+	}
+	sealSource := []byte(secret)
+	if k := os.Getenv("JWT_KEY_ENCRYPTION_KEY"); k != "" {
+		sealSource = []byte(k)
+	}
+	store := auth.NewKeyStore(pool, auth.SigningKeySealKey(sealSource), 0)
+
+	err = run(ctx, store, os.Args[1:], os.Stdout)
+	if errors.Is(err, errUsage) {
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, store keyStore, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "list":
+		return list(ctx, store, out)
+	case "rotate":
+		return rotate(ctx, store, args[1:], out)
+	case "delete":
+		if len(args) != 2 {
+			return errUsage
+		}
+		if err := store.Delete(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "deleted %s; API replicas stop accepting its tokens within a minute\n", args[1])
+		return nil
+	}
+	return errUsage
+}
+
+func list(ctx context.Context, store keyStore, out io.Writer) error {
+	keys, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSTATUS\tCREATED\tACTIVATES\tRETIRED")
+	for _, key := range keys {
+		retired := "-"
+		if key.RetiredAt != nil {
+			retired = key.RetiredAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			key.ID, key.Algorithm, key.Status(now),
+			key.CreatedAt.Format(time.RFC3339), key.ActivatesAt.Format(time.RFC3339), retired,
+		)
+	}
+	return w.Flush()
+}
+
+func rotate(ctx context.Context, store keyStore, args []string, out io.Writer) error {
+	defaultAlg := os.Getenv("JWT_SIGNING_ALG")
+	if defaultAlg == "" {
+		defaultAlg = auth.AlgEdDSA
+	}
+
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	alg := fs.String("alg", defaultAlg, "signing algorithm: EdDSA or ES256")
+	delay := fs.Duration("delay", auth.DefaultKeyPublishDelay, "how long the new key is published before it starts signing")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	if *delay < 0 {
+		return errors.New("delay must not be negative")
+	}
+
+	key, err := store.Rotate(ctx, *alg, *delay)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "created %s (%s), signs from %s; previous keys retire at that moment\n",
+		key.ID, key.Algorithm, key.ActivatesAt.Format(time.RFC3339))
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, errUsage)
+	os.Exit(2)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"passkeys/internal/auth"
+)
+
+// fakeStore держит ключи в памяти и ротирует их так же, как auth.KeyStore.
+type fakeStore struct {
+	keys []*auth.SigningKey
+	now  time.Time
+}
+
+func (s *fakeStore) List(context.Context) ([]*auth.SigningKey, error) {
+	return s.keys, nil
+}
+
+func (s *fakeStore) Rotate(_ context.Context, algorithm string, publishDelay time.Duration) (*auth.SigningKey, error) {
+	key, err := auth.GenerateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = s.now
+	key.ActivatesAt = s.now.Add(publishDelay)
+	for _, old := range s.keys {
+		if old.RetiredAt == nil {
+			retiredAt := key.ActivatesAt
+			old.RetiredAt = &retiredAt
+		}
+	}
+	s.keys = append(s.keys, key)
+	return key, nil
+}
+
+func (s *fakeStore) Delete(_ context.Context, kid string) error {
+	for i, key := range s.keys {
+		if key.ID == kid {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			return nil
+		}
+	}
+	return auth.ErrUnknownKey
+}
+
+func runCommand(t *testing.T, store *fakeStore, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := run(context.Background(), store, args, &out)
+	return out.String(), err
+}
+
+func TestRotate(t *testing.T) {
+	t.Setenv("JWT_SIGNING_ALG", "")
+	now := time.Now().Truncate(time.Second)
+	store := &fakeStore{now: now}
+
+	out, err := runCommand(t, store, "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := store.keys[0]
+	if first.Algorithm != auth.AlgEdDSA || !first.ActivatesAt.Equal(now.Add(auth.DefaultKeyPublishDelay)) {
+		t.Errorf("default rotate created %s activating at %s", first.Algorithm, first.ActivatesAt)
+	}
+	if !strings.Contains(out, "created "+first.ID+" (EdDSA)") {
+		t.Errorf("output = %q", out)
+	}
+
+	if _, err := runCommand(t, store, "rotate", "-alg", "ES256", "-delay", "0s"); err != nil {
+		t.Fatal(err)
+	}
+	second := store.keys[1]
+	if second.Algorithm != auth.AlgES256 || !second.ActivatesAt.Equal(now) {
+		t.Errorf("rotate created %s activating at %s", second.Algorithm, second.ActivatesAt)
+	}
+	if first.RetiredAt == nil || !first.RetiredAt.Equal(second.ActivatesAt) {
+		t.Errorf("previous key retires at %v, want %s", first.RetiredAt, second.ActivatesAt)
+	}
+
+	t.Setenv("JWT_SIGNING_ALG", auth.AlgES256)
+	if _, err := runCommand(t, store, "rotate"); err != nil {
+		t.Fatal(err)
+	}
+	if alg := store.keys[2].Algorithm; alg != auth.AlgES256 {
+		t.Errorf("JWT_SIGNING_ALG ignored: %s", alg)
+	}
+}
+
+func TestRotateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{"negative delay", []string{"rotate", "-delay", "-1m"}, nil},
+		{"bad delay", []string{"rotate", "-delay", "soon"}, errUsage},
+		{"unknown flag", []string{"rotate", "-force"}, errUsage},
+		{"extra argument", []string{"rotate", "now"}, errUsage},
+		{"unsupported algorithm", []string{"rotate", "-alg", "HS256"}, auth.ErrUnsupportedAlgorithm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{now: time.Now()}
+			_, err := runCommand(t, store, tt.args...)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(store.keys) != 0 {
+				t.Errorf("key created despite error")
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	now := time.Now()
+	store := &fakeStore{now: now.Add(-time.Hour)}
+	if _, err := runCommand(t, store, "rotate", "-delay", "0s"); err != nil {
+		t.Fatal(err)
+	}
+	store.now = now
+	if _, err := runCommand(t, store, "rotate", "-alg", "ES256", "-delay", "1h"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCommand(t, store, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "KID") {
+		t.Fatalf("list output:\n%s", out)
+	}
+	// Старый ключ ещё подписывает до активации нового, новый пока только опубликован.
+	if fields := strings.Fields(lines[1]); fields[0] != store.keys[0].ID || fields[2] != "active" || fields[5] == "-" {
+		t.Errorf("current key row = %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != store.keys[1].ID || fields[1] != "ES256" || fields[2] != "pending" || fields[5] != "-" {
+		t.Errorf("new key row = %q", lines[2])
+	}
+}
+
+func TestDelete(t *testing.T) {
+	store := &fakeStore{now: time.Now()}
+	if _, err := runCommand(t, store, "rotate"); err != nil {
+		t.Fatal(err)
+	}
+	kid := store.keys[0].ID
+
+	if _, err := runCommand(t, store, "delete", "unknown"); !errors.Is(err, auth.ErrUnknownKey) {
+		t.Errorf("unknown kid: err = %v", err)
+	}
+	out, err := runCommand(t, store, "delete", kid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.keys) != 0 || !strings.HasPrefix(out, "deleted "+kid) {
+		t.Errorf("delete output %q, keys left %d", out, len(store.keys))
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"help"}, {"delete"}, {"delete", "a", "b"}} {
+		if err := run(context.Background(), &fakeStore{}, args, io.Discard); !errors.Is(err, errUsage) {
+			t.Errorf("%q: err = %v, want errUsage", args, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Алгоритмы подписи JWT (значения заголовка alg).
+const (
+	AlgEdDSA = "EdDSA"
+	AlgES256 = "ES256"
+)
+
+var (
+	ErrNoSigningKey         = errors.New("no active signing key")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// SigningKey — ключ подписи токенов. Kid — отпечаток открытого ключа по RFC 7638.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+	// ActivatesAt — с этого момента ключ подписывает; до него только опубликован в JWKS.
+	ActivatesAt time.Time
+	// RetiredAt — с этого момента ключ только проверяет ранее выданные токены.
+	RetiredAt *time.Time
+}
+
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var private crypto.Signer
+	switch algorithm {
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return newSigningKey(algorithm, private.Public(), private)
+}
+
+func newSigningKey(algorithm string, public crypto.PublicKey, private crypto.Signer) (*SigningKey, error) {
+	key := &SigningKey{Algorithm: algorithm, Public: public, Private: private}
+	jwk, err := key.JWK()
+	if err != nil {
+		return nil, err
+	}
+	key.ID = jwk.thumbprint()
+	return key, nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	case AlgES256:
+		return jwt.SigningMethodES256
+	}
+	return nil
+}
+
+// Status — состояние ключа на момент now: pending, active или retiring.
+func (k *SigningKey) Status(now time.Time) string {
+	switch {
+	case k.RetiredAt != nil && !now.Before(*k.RetiredAt):
+		return "retiring"
+	case now.Before(k.ActivatesAt):
+		return "pending"
+	}
+	return "active"
+}
+
+// JWK — открытый ключ в формате RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *SigningKey) JWK() (JWK, error) {
+	switch pub := k.Public.(type) {
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+			Kid: k.ID,
+			Alg: k.Algorithm,
+			Use: "sig",
+		}, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return JWK{}, ErrUnsupportedAlgorithm
+		}
+		return JWK{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+			Kid: k.ID,
+			Alg: k.Algorithm,
+			Use: "sig",
+		}, nil
+	}
+	return JWK{}, ErrUnsupportedAlgorithm
+}
+
+// thumbprint — RFC 7638: sha256 от обязательных полей в лексикографическом порядке.
+func (j JWK) thumbprint() string {
+	var canonical string
+	if j.Kty == "EC" {
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, j.Crv, j.Kty, j.X, j.Y)
+	} else {
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, j.Crv, j.Kty, j.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// KeySet — ключи, которыми API подписывает и проверяет токены. Подписывает самый
+// свежий активный ключ; ожидающие и выводимые ключи участвуют только в проверке.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+}
+
+func NewKeySet(keys ...*SigningKey) *KeySet {
+	return &KeySet{keys: keys}
+}
+
+// Replace подменяет набор целиком (после перечитывания из БД).
+func (s *KeySet) Replace(keys []*SigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *KeySet) signer(now time.Time) (*SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var current *SigningKey
+	for _, key := range s.keys {
+		if key.Private == nil || key.Status(now) != "active" {
+			continue
+		}
+		if current == nil || key.ActivatesAt.After(current.ActivatesAt) {
+			current = key
+		}
+	}
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+	return current, nil
+}
+
+func (s *KeySet) lookup(kid string) (*SigningKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Sign подписывает claims текущим ключом и указывает в заголовке его kid и тип токена typ.
+func (s *KeySet) Sign(claims jwt.Claims, typ string) (string, error) {
+	key, err := s.signer(time.Now())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = typ
+	return token.SignedString(key.Private)
+}
+
+// keyfunc выбирает ключ по kid и не даёт подменить алгоритм: токен должен быть
+// подписан именно тем алгоритмом, для которого создан ключ.
+func (s *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.lookup(kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return key.Public, nil
+}
+
+// JWKS — открытые части всех ключей набора, включая ожидающие и выводимые.
+func (s *KeySet) JWKS() JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwk, err := key.JWK()
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func mustKey(t *testing.T, algorithm string, activatesAt time.Time) *SigningKey {
+	t.Helper()
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.CreatedAt = activatesAt
+	key.ActivatesAt = activatesAt
+	return key
+}
+
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestGenerateSigningKey(t *testing.T) {
+	for _, algorithm := range []string{AlgEdDSA, AlgES256} {
+		key := mustKey(t, algorithm, time.Now())
+		jwk, err := key.JWK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID == "" || jwk.Kid != key.ID || jwk.thumbprint() != key.ID {
+			t.Errorf("%s: kid %q, jwk %+v", algorithm, key.ID, jwk)
+		}
+		if other := mustKey(t, algorithm, time.Now()); other.ID == key.ID {
+			t.Errorf("%s: two keys share kid %q", algorithm, key.ID)
+		}
+	}
+	if _, err := GenerateSigningKey("HS256"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("HS256 error = %v, want ErrUnsupportedAlgorithm", err)
+	}
+}
+
+func TestThumbprintRFC7638(t *testing.T) {
+	// Пример из RFC 8037, раздел A.3.
+	jwk := JWK{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+	if got, want := jwk.thumbprint(), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; got != want {
+		t.Errorf("thumbprint = %s, want %s", got, want)
+	}
+}
+
+func TestSigningKeyStatus(t *testing.T) {
+	now := time.Now()
+	retired := now.Add(-time.Minute)
+	retiring := now.Add(time.Minute)
+	tests := []struct {
+		name string
+		key  SigningKey
+		want string
+	}{
+		{"active", SigningKey{ActivatesAt: now.Add(-time.Hour)}, "active"},
+		{"activates now", SigningKey{ActivatesAt: now}, "active"},
+		{"pending", SigningKey{ActivatesAt: now.Add(time.Minute)}, "pending"},
+		{"retired", SigningKey{ActivatesAt: now.Add(-time.Hour), RetiredAt: &retired}, "retiring"},
+		{"retires later", SigningKey{ActivatesAt: now.Add(-time.Hour), RetiredAt: &retiring}, "active"},
+	}
+	for _, tt := range tests {
+		if got := tt.key.Status(now); got != tt.want {
+			t.Errorf("%s: status = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKeySetSigner(t *testing.T) {
+	now := time.Now()
+	old := mustKey(t, AlgEdDSA, now.Add(-2*time.Hour))
+	current := mustKey(t, AlgES256, now.Add(-time.Hour))
+	pending := mustKey(t, AlgEdDSA, now.Add(time.Hour))
+	retiredAt := now.Add(-time.Minute)
+	retired := mustKey(t, AlgEdDSA, now.Add(-time.Minute/2))
+	retired.RetiredAt = &retiredAt
+	publicOnly := mustKey(t, AlgEdDSA, now.Add(-time.Second))
+	publicOnly.Private = nil
+
+	keys := NewKeySet(old, pending, current, retired, publicOnly)
+	token, err := CreateToken(keys, "user-1", "user@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKid(t, token); kid != current.ID {
+		t.Errorf("signed with %s, want the newest active key %s", kid, current.ID)
+	}
+
+	// Токены, подписанные ключом до его вывода, проверяются, пока ключ есть в наборе.
+	signedByOld, err := CreateToken(NewKeySet(old), "user-1", "user@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.RetiredAt = &retiredAt
+	if _, err := ParseToken(keys, signedByOld); err != nil {
+		t.Errorf("token of a retiring key: %v", err)
+	}
+
+	// Удалённый ключ больше ничего не проверяет.
+	keys.Replace([]*SigningKey{current})
+	if _, err := ParseToken(keys, signedByOld); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of a deleted key: err = %v, want ErrUnknownKey", err)
+	}
+
+	keys.Replace([]*SigningKey{pending, retired, publicOnly})
+	if _, err := CreateToken(keys, "user-1", "user@example.com", ""); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("no active key: err = %v, want ErrNoSigningKey", err)
+	}
+}
+
+func TestKeySetAlgorithms(t *testing.T) {
+	now := time.Now()
+	edKey := mustKey(t, AlgEdDSA, now)
+	ecKey := mustKey(t, AlgES256, now)
+	keys := NewKeySet(edKey, ecKey)
+	claims := func() *Claims {
+		return &Claims{
+			UserID: "user-1",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    Issuer,
+				Audience:  jwt.ClaimStrings{AudienceAPI},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+		}
+	}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims())
+		token.Header["typ"] = TypeAccess
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	_, strangerKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"EdDSA", sign(jwt.SigningMethodEdDSA, edKey.ID, edKey.Private), nil},
+		{"ES256", sign(jwt.SigningMethodES256, ecKey.ID, ecKey.Private), nil},
+		// Алгоритм в заголовке должен совпадать с алгоритмом ключа с этим kid.
+		{"ES256 with EdDSA kid", sign(jwt.SigningMethodES256, edKey.ID, ecKey.Private), ErrUnsupportedAlgorithm},
+		{"EdDSA with ES256 kid", sign(jwt.SigningMethodEdDSA, ecKey.ID, edKey.Private), ErrUnsupportedAlgorithm},
+		{"HS256 keyed with kid", sign(jwt.SigningMethodHS256, edKey.ID, []byte(edKey.ID)), jwt.ErrTokenSignatureInvalid},
+		{"alg none", sign(jwt.SigningMethodNone, edKey.ID, jwt.UnsafeAllowNoneSignatureType), jwt.ErrTokenSignatureInvalid},
+		{"missing kid", sign(jwt.SigningMethodEdDSA, "", edKey.Private), ErrUnknownKey},
+		{"unknown kid", sign(jwt.SigningMethodEdDSA, "unknown", edKey.Private), ErrUnknownKey},
+		{"foreign key with known kid", sign(jwt.SigningMethodEdDSA, edKey.ID, strangerKey), jwt.ErrTokenSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseToken(keys, tt.token)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenClaims(t *testing.T) {
+	now := time.Now()
+	key := mustKey(t, AlgEdDSA, now.Add(-time.Minute))
+	keys := NewKeySet(key)
+
+	access, err := CreateToken(keys, "user-1", "user@example.com", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(keys, access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != "user-1" || claims.SessionID != "session-1" || claims.Issuer != Issuer ||
+		len(claims.Audience) != 1 || claims.Audience[0] != AudienceAPI || claims.ID == "" {
+		t.Errorf("access claims = %+v", claims)
+	}
+
+	mfa, err := CreateMFAToken(keys, "user-1", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = ParseMFAToken(keys, mfa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Purpose != PurposeMFA || len(claims.Audience) != 1 || claims.Audience[0] != AudienceMFA {
+		t.Errorf("mfa claims = %+v", claims)
+	}
+
+	if _, err := ParseToken(keys, mfa); err == nil {
+		t.Error("MFA token accepted as an access token")
+	}
+	if _, err := ParseMFAToken(keys, access); err == nil {
+		t.Error("access token accepted as an MFA token")
+	}
+
+	expired, err := CreateTokenWithLifetime(keys, "user-1", "user@example.com", "", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseToken(keys, expired); !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("expired token: err = %v", err)
+	}
+}
+
+func TestParseTokenChecksIssuerAudienceAndType(t *testing.T) {
+	now := time.Now()
+	key := mustKey(t, AlgEdDSA, now.Add(-time.Minute))
+	keys := NewKeySet(key)
+
+	tests := []struct {
+		name   string
+		modify func(c *Claims, header map[string]interface{})
+		// mfa — подпись и проверка как у MFA-токена.
+		mfa     bool
+		wantErr error
+	}{
+		{name: "valid", modify: func(*Claims, map[string]interface{}) {}},
+		{name: "foreign issuer", modify: func(c *Claims, _ map[string]interface{}) { c.Issuer = "https://idp.example.com" }, wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "missing issuer", modify: func(c *Claims, _ map[string]interface{}) { c.Issuer = "" }, wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "foreign audience", modify: func(c *Claims, _ map[string]interface{}) { c.Audience = jwt.ClaimStrings{"other-api"} }, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "missing audience", modify: func(c *Claims, _ map[string]interface{}) { c.Audience = nil }, wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "mfa audience", modify: func(c *Claims, _ map[string]interface{}) { c.Audience = jwt.ClaimStrings{AudienceMFA} }, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "mfa type", modify: func(_ *Claims, h map[string]interface{}) { h["typ"] = TypeMFA }, wantErr: ErrWrongPurpose},
+		{name: "plain JWT type", modify: func(_ *Claims, h map[string]interface{}) { h["typ"] = "JWT" }, wantErr: ErrWrongPurpose},
+		{name: "mfa purpose", modify: func(c *Claims, _ map[string]interface{}) { c.Purpose = PurposeMFA }, wantErr: ErrWrongPurpose},
+		{name: "mfa valid", mfa: true, modify: func(*Claims, map[string]interface{}) {}},
+		{name: "mfa with access audience", mfa: true, modify: func(c *Claims, _ map[string]interface{}) { c.Audience = jwt.ClaimStrings{AudienceAPI} }, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "mfa with access type", mfa: true, modify: func(_ *Claims, h map[string]interface{}) { h["typ"] = TypeAccess }, wantErr: ErrWrongPurpose},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &Claims{
+				UserID: "user-1",
+				RegisteredClaims: jwt.RegisteredClaims{
+					Issuer:    Issuer,
+					Audience:  jwt.ClaimStrings{AudienceAPI},
+					ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+				},
+			}
+			typ := TypeAccess
+			if tt.mfa {
+				claims.Purpose = PurposeMFA
+				claims.Audience = jwt.ClaimStrings{AudienceMFA}
+				typ = TypeMFA
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+			token.Header["kid"] = key.ID
+			token.Header["typ"] = typ
+			tt.modify(claims, token.Header)
+			signed, err := token.SignedString(key.Private)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parse := ParseToken
+			if tt.mfa {
+				parse = ParseMFAToken
+			}
+			_, err = parse(keys, signed)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	retiredAt := now.Add(-time.Minute)
+	active := mustKey(t, AlgEdDSA, now.Add(-time.Hour))
+	pending := mustKey(t, AlgES256, now.Add(time.Hour))
+	retired := mustKey(t, AlgEdDSA, now.Add(-2*time.Hour))
+	retired.RetiredAt = &retiredAt
+
+	set := NewKeySet(active, pending, retired).JWKS()
+	if len(set.Keys) != 3 {
+		t.Fatalf("JWKS has %d keys, want 3", len(set.Keys))
+	}
+	kids := map[string]JWK{}
+	for _, jwk := range set.Keys {
+		kids[jwk.Kid] = jwk
+		if jwk.Use != "sig" || strings.Contains(jwk.X, "=") {
+			t.Errorf("jwk = %+v", jwk)
+		}
+	}
+	if kids[pending.ID].Kty != "EC" || kids[pending.ID].Y == "" || kids[active.ID].Kty != "OKP" || kids[active.ID].Y != "" {
+		t.Errorf("JWKS = %+v", set)
+	}
+	if len(NewKeySet().JWKS().Keys) != 0 {
+		t.Error("empty key set publishes keys")
+	}
+}
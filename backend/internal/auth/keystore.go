@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultKeyPublishDelay — сколько новый ключ висит в JWKS до начала подписи:
+// за это время его успевают подхватить реплики API и кэши других сервисов.
+const DefaultKeyPublishDelay = 10 * time.Minute
+
+// KeyStore хранит ключи подписи в таблице signing_keys; закрытые ключи зашифрованы SealKey.
+type KeyStore struct {
+	DB      *pgxpool.Pool
+	SealKey []byte
+	// Retention — сколько выведенный ключ ещё проверяет токены: не меньше времени жизни access-токена.
+	Retention time.Duration
+	// Keys — набор, который обновляет Reload.
+	Keys *KeySet
+}
+
+// SigningKeySealKey выводит ключ шифрования закрытых ключей подписи. API и команда
+// jwtkeys должны получать его из одного и того же секрета.
+func SigningKeySealKey(secret []byte) []byte {
+	return DeriveKey(secret, "passkeys/jwt-keys")
+}
+
+func NewKeyStore(db *pgxpool.Pool, sealKey []byte, retention time.Duration) *KeyStore {
+	return &KeyStore{DB: db, SealKey: sealKey, Retention: retention, Keys: NewKeySet()}
+}
+
+// Reload перечитывает ключи из БД в Keys; запускается периодически на каждой реплике.
+func (s *KeyStore) Reload(ctx context.Context) error {
+	keys, err := s.load(ctx, "where retired_at is null or retired_at > now() - make_interval(secs => $1)", s.Retention.Seconds())
+	if err != nil {
+		return err
+	}
+	s.Keys.Replace(keys)
+	return nil
+}
+
+// List возвращает все ключи, включая выведенные, но ещё не удалённые.
+func (s *KeyStore) List(ctx context.Context) ([]*SigningKey, error) {
+	return s.load(ctx, "")
+}
+
+// EnsureSigningKey создаёт сразу активный ключ, если действующих ключей нет (первый запуск).
+func (s *KeyStore) EnsureSigningKey(ctx context.Context, algorithm string) error {
+	_, err := s.rotate(ctx, algorithm, 0, true)
+	return err
+}
+
+// Rotate добавляет новый ключ, который начнёт подписывать через publishDelay.
+// Текущие ключи в тот же момент выводятся и дальше только проверяют токены.
+func (s *KeyStore) Rotate(ctx context.Context, algorithm string, publishDelay time.Duration) (*SigningKey, error) {
+	return s.rotate(ctx, algorithm, publishDelay, false)
+}
+
+func (s *KeyStore) rotate(ctx context.Context, algorithm string, publishDelay time.Duration, onlyIfMissing bool) (*SigningKey, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Реплики при старте и команда ротации не должны создать ключи параллельно.
+	if _, err := tx.Exec(ctx, "select pg_advisory_xact_lock(hashtext('signing_keys'))"); err != nil {
+		return nil, err
+	}
+
+	if onlyIfMissing {
+		var exists bool
+		if err := tx.QueryRow(ctx, "select exists(select 1 from signing_keys where retired_at is null)").Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, nil
+		}
+	}
+
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		return nil, err
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return nil, err
+	}
+	cipher, nonce, err := Seal(s.SealKey, private)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.QueryRow(ctx, `
+		insert into signing_keys (id, algorithm, public_key, private_key_cipher, private_key_nonce, activates_at)
+		values ($1, $2, $3, $4, $5, now() + make_interval(secs => $6))
+		returning created_at, activates_at`,
+		key.ID, key.Algorithm, public, cipher, nonce, publishDelay.Seconds(),
+	).Scan(&key.CreatedAt, &key.ActivatesAt); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx,
+		"update signing_keys set retired_at=$1 where retired_at is null and id<>$2",
+		key.ActivatesAt, key.ID,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Delete немедленно удаляет ключ (например, при компрометации): все подписанные им токены
+// перестают приниматься после следующего Reload.
+func (s *KeyStore) Delete(ctx context.Context, kid string) error {
+	commandTag, err := s.DB.Exec(ctx, "delete from signing_keys where id=$1", kid)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrUnknownKey
+	}
+	return nil
+}
+
+// PurgeRetired удаляет ключи, выведенные дольше Retention назад.
+func (s *KeyStore) PurgeRetired(ctx context.Context) error {
+	_, err := s.DB.Exec(ctx,
+		"delete from signing_keys where retired_at < now() - make_interval(secs => $1)", s.Retention.Seconds(),
+	)
+	return err
+}
+
+func (s *KeyStore) load(ctx context.Context, where string, args ...any) ([]*SigningKey, error) {
+	rows, err := s.DB.Query(ctx, `
+		select id, algorithm, public_key, private_key_cipher, private_key_nonce, created_at, activates_at, retired_at
+		from signing_keys `+where+` order by activates_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*SigningKey, 0)
+	for rows.Next() {
+		key, err := s.scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *KeyStore) scanKey(row pgx.Row) (*SigningKey, error) {
+	var (
+		key                   SigningKey
+		public, cipher, nonce []byte
+	)
+	if err := row.Scan(&key.ID, &key.Algorithm, &public, &cipher, &nonce, &key.CreatedAt, &key.ActivatesAt, &key.RetiredAt); err != nil {
+		return nil, err
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := Open(s.SealKey, cipher, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: cannot decrypt private key: %w", key.ID, err)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	key.Public = pub
+	key.Private = signer
+	return &key, nil
+}
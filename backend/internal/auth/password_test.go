@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHasher — дешёвые параметры, чтобы тесты не тратили 19 МиБ на каждый хэш.
+func testHasher() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := testHasher()
+	encoded, err := h.Hash([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, salt, key, err := parseArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("parse %q: %v", encoded, err)
+	}
+	if params != *h {
+		t.Errorf("params = %+v, want %+v", params, *h)
+	}
+	if len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Errorf("salt %d bytes, key %d bytes", len(salt), len(key))
+	}
+
+	if ok, err := h.Verify(encoded, []byte("secret")); !ok || err != nil {
+		t.Errorf("Verify(correct) = %v, %v", ok, err)
+	}
+	if ok, err := h.Verify(encoded, []byte("wrong")); ok || err != nil {
+		t.Errorf("Verify(wrong) = %v, %v", ok, err)
+	}
+	if h.NeedsRehash(encoded) {
+		t.Error("fresh hash needs rehash")
+	}
+}
+
+func TestArgon2idVerifyUsesStoredParams(t *testing.T) {
+	encoded, err := testHasher().Hash([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Хэш со старыми параметрами проверяется ими же, но требует пересчёта.
+	current := &Argon2idHasher{Memory: 128, Iterations: 2, Parallelism: 1}
+	if ok, err := current.Verify(encoded, []byte("secret")); !ok || err != nil {
+		t.Errorf("Verify = %v, %v", ok, err)
+	}
+	if !current.NeedsRehash(encoded) {
+		t.Error("hash with old params does not need rehash")
+	}
+}
+
+func TestBcryptVerifyAndRehash(t *testing.T) {
+	encoded, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := testHasher()
+	if ok, err := h.Verify(string(encoded), []byte("secret")); !ok || err != nil {
+		t.Errorf("Verify(correct) = %v, %v", ok, err)
+	}
+	if ok, err := h.Verify(string(encoded), []byte("wrong")); ok || err != nil {
+		t.Errorf("Verify(wrong) = %v, %v", ok, err)
+	}
+	if !h.NeedsRehash(string(encoded)) {
+		t.Error("bcrypt hash does not need rehash")
+	}
+}
+
+func TestParseArgon2idMalformed(t *testing.T) {
+	const salt, key = "c29tZXNhbHRzb21lc2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"garbage", "not a hash"},
+		{"argon2i", "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key},
+		{"missing part", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"extra part", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$x"},
+		{"no leading dollar", "argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$"},
+		{"old version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"bad version", "$argon2id$version$m=64,t=1,p=1$" + salt + "$" + key},
+		{"bad params", "$argon2id$v=19$t=1,m=64,p=1$" + salt + "$" + key},
+		{"zero memory", "$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key},
+		{"zero iterations", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{"zero parallelism", "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key},
+		{"negative memory", "$argon2id$v=19$m=-1,t=1,p=1$" + salt + "$" + key},
+		{"memory overflow", "$argon2id$v=19$m=4294967296,t=1,p=1$" + salt + "$" + key},
+		{"parallelism overflow", "$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key},
+		{"padded salt", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "==$" + key},
+		{"bad key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!"},
+		{"empty key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+	}
+	h := testHasher()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := parseArgon2id(tt.encoded); !errors.Is(err, ErrUnknownHashFormat) {
+				t.Errorf("parseArgon2id(%q) error = %v, want ErrUnknownHashFormat", tt.encoded, err)
+			}
+			if ok, err := h.Verify(tt.encoded, []byte("secret")); ok || err == nil {
+				t.Errorf("Verify(%q) = %v, %v, want error", tt.encoded, ok, err)
+			}
+			if !h.NeedsRehash(tt.encoded) {
+				t.Errorf("NeedsRehash(%q) = false", tt.encoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PersonalTokenPrefix отличает персональный токен от JWT в заголовке Authorization
+// и помогает сканерам секретов находить утёкшие токены.
+const PersonalTokenPrefix = "pkp_"
+
+// Scopes персональных токенов: ресурс и право чтения или записи.
+const (
+	ScopeAccountsRead  = "accounts:read"
+	ScopeAccountsWrite = "accounts:write"
+	ScopeNotesRead     = "notes:read"
+	ScopeNotesWrite    = "notes:write"
+	// Записи любого типа через /items, включая аккаунты и заметки.
+	ScopeItemsRead    = "items:read"
+	ScopeItemsWrite   = "items:write"
+	ScopeFoldersRead  = "folders:read"
+	ScopeFoldersWrite = "folders:write"
+)
+
+var AllScopes = []string{
+	ScopeAccountsRead, ScopeAccountsWrite, ScopeNotesRead, ScopeNotesWrite,
+	ScopeItemsRead, ScopeItemsWrite, ScopeFoldersRead, ScopeFoldersWrite,
+}
+
+var ErrInvalidPersonalToken = errors.New("personal access token is invalid or expired")
+
+// lastUsedResolution — чаще этого last_used_at не обновляется, чтобы не писать в БД на каждый запрос.
+const lastUsedResolution = time.Minute
+
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func GeneratePersonalToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// HashPersonalToken — sha256 в hex; в БД хранится только он.
+func HashPersonalToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type PersonalToken struct {
+	ID     string
+	UserID string
+	Email  string
+	Scopes []string
+}
+
+// PersonalTokenStore проверяет персональные токены и отмечает их использование.
+type PersonalTokenStore struct {
+	DB *pgxpool.Pool
+}
+
+func (s *PersonalTokenStore) Lookup(ctx context.Context, token string) (PersonalToken, error) {
+	var pt PersonalToken
+	var lastUsedAt *time.Time
+	err := s.DB.QueryRow(ctx, `
+		select t.id, t.user_id, u.email, t.scopes, t.last_used_at
+		from personal_access_tokens t join users u on u.id = t.user_id
+		where t.token_hash=$1 and (t.expires_at is null or t.expires_at>now()) and u.delete_after is null and u.disabled_at is null`,
+		HashPersonalToken(token),
+	).Scan(&pt.ID, &pt.UserID, &pt.Email, &pt.Scopes, &lastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PersonalToken{}, ErrInvalidPersonalToken
+	}
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	if lastUsedAt == nil || time.Since(*lastUsedAt) > lastUsedResolution {
+		if _, err := s.DB.Exec(ctx, "update personal_access_tokens set last_used_at=now() where id=$1", pt.ID); err != nil {
+			return PersonalToken{}, err
+		}
+	}
+	return pt, nil
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Роли пользователей (users.role).
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// RoleStore читает роль из БД на каждый запрос: снятие прав администратора
+// и отключение аккаунта действуют сразу, без перевыпуска токенов.
+type RoleStore struct {
+	DB *pgxpool.Pool
+}
+
+// Role возвращает роль пользователя; у отключённого или удалённого пользователя роли нет ("").
+func (s *RoleStore) Role(ctx context.Context, userID string) (string, error) {
+	var role string
+	err := s.DB.QueryRow(ctx,
+		"select role from users where id=$1 and disabled_at is null and delete_after is null", userID,
+	).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
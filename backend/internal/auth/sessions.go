@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultSessionCacheTTL — как долго реплика верит, что сессия жива, не спрашивая БД.
+// Отзыв на той же реплике действует сразу, на остальных — не позже чем через это время.
+const DefaultSessionCacheTTL = 15 * time.Second
+
+// maxCachedSessions — после этого размера кэш вычищается от устаревших записей.
+const maxCachedSessions = 10000
+
+// SessionStore проверяет, что сессия access-токена не отозвана, с кэшем в памяти процесса.
+type SessionStore struct {
+	DB  *pgxpool.Pool
+	TTL time.Duration
+	// RevokedTTL — сколько помнить отозванную сессию; не меньше времени жизни access-токена.
+	RevokedTTL time.Duration
+
+	mu      sync.Mutex
+	active  map[string]time.Time
+	revoked map[string]time.Time
+}
+
+func NewSessionStore(db *pgxpool.Pool, accessTokenLifetime time.Duration) *SessionStore {
+	return &SessionStore{
+		DB:         db,
+		TTL:        DefaultSessionCacheTTL,
+		RevokedTTL: accessTokenLifetime,
+		active:     make(map[string]time.Time),
+		revoked:    make(map[string]time.Time),
+	}
+}
+
+func (s *SessionStore) Active(ctx context.Context, sessionID string) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if until, ok := s.revoked[sessionID]; ok && now.Before(until) {
+		s.mu.Unlock()
+		return false, nil
+	}
+	if until, ok := s.active[sessionID]; ok && now.Before(until) {
+		s.mu.Unlock()
+		return true, nil
+	}
+	s.mu.Unlock()
+
+	var exists bool
+	if err := s.DB.QueryRow(ctx, "select exists(select 1 from sessions where id::text=$1)", sessionID).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	if exists {
+		s.active[sessionID] = now.Add(s.TTL)
+	} else {
+		s.revoked[sessionID] = now.Add(s.RevokedTTL)
+	}
+	return exists, nil
+}
+
+// Revoke сразу отмечает сессии отозванными в кэше этой реплики. Строки в БД удаляет вызывающий.
+func (s *SessionStore) Revoke(sessionIDs ...string) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	for _, id := range sessionIDs {
+		delete(s.active, id)
+		s.revoked[id] = now.Add(s.RevokedTTL)
+	}
+}
+
+func (s *SessionStore) prune(now time.Time) {
+	if len(s.active)+len(s.revoked) < maxCachedSessions {
+		return
+	}
+	for id, until := range s.active {
+		if now.After(until) {
+			delete(s.active, id)
+		}
+	}
+	for id, until := range s.revoked {
+		if now.After(until) {
+			delete(s.revoked, id)
+		}
+	}
+}
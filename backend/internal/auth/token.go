@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,42 +12,115 @@ import (
 type Claims struct {
 	UserID string `json:"uid"`
 	Email  string `json:"email"`
+	// Purpose пуст у access-токенов; токены с другим назначением (например, PurposeMFA)
+	// не принимаются как access-токены.
+	Purpose string `json:"purpose,omitempty"`
+	// SessionID — сессия (строка sessions), в рамках которой выдан access-токен.
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// PurposeMFA — токен "ожидается второй фактор", выдаётся после первого фактора (пароль или SSO).
+const PurposeMFA = "mfa"
+
+var ErrWrongPurpose = errors.New("token has wrong purpose")
+
+// Issuer — iss всех токенов API. Audience различает access- и MFA-токены, чтобы
+// сервисы, проверяющие подпись по JWKS, не приняли токен не по назначению.
+const (
+	Issuer      = "passkeys"
+	AudienceAPI = "passkeys-api"
+	AudienceMFA = "passkeys-mfa"
+)
+
+// Значения заголовка typ: access-токены по RFC 9068, MFA-токены — свой тип.
+const (
+	TypeAccess = "at+jwt"
+	TypeMFA    = "mfa+jwt"
+)
+
 // DefaultAccessTokenLifetime — 1 час.
 const DefaultAccessTokenLifetime = 1 * time.Hour
 
 // DefaultRefreshTokenLifetime — 30 дней.
 const DefaultRefreshTokenLifetime = 30 * 24 * time.Hour
 
-func CreateToken(secret []byte, userID, email string) (string, error) {
-	return CreateTokenWithLifetime(secret, userID, email, DefaultAccessTokenLifetime)
+// MFATokenLifetime — сколько есть у пользователя на ввод второго фактора.
+const MFATokenLifetime = 5 * time.Minute
+
+func CreateToken(keys *KeySet, userID, email, sessionID string) (string, error) {
+	return CreateTokenWithLifetime(keys, userID, email, sessionID, DefaultAccessTokenLifetime)
+}
+
+func CreateTokenWithLifetime(keys *KeySet, userID, email, sessionID string, lifetime time.Duration) (string, error) {
+	return createToken(keys, userID, email, sessionID, "", lifetime)
+}
+
+func CreateMFAToken(keys *KeySet, userID, email string) (string, error) {
+	return createToken(keys, userID, email, "", PurposeMFA, MFATokenLifetime)
 }
 
-func CreateTokenWithLifetime(secret []byte, userID, email string, lifetime time.Duration) (string, error) {
+func createToken(keys *KeySet, userID, email, sessionID, purpose string, lifetime time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	audience, typ := AudienceAPI, TypeAccess
+	if purpose == PurposeMFA {
+		audience, typ = AudienceMFA, TypeMFA
+	}
+
 	now := time.Now()
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		Purpose:   purpose,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			ID:        hex.EncodeToString(jti),
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+	return keys.Sign(claims, typ)
+}
+
+// ParseToken разбирает access-токен.
+func ParseToken(keys *KeySet, tokenString string) (*Claims, error) {
+	return parseToken(keys, tokenString, "")
 }
 
-func ParseToken(secret []byte, tokenString string) (*Claims, error) {
-	parsed, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
+// ParseMFAToken разбирает токен, выданный CreateMFAToken.
+func ParseMFAToken(keys *KeySet, tokenString string) (*Claims, error) {
+	return parseToken(keys, tokenString, PurposeMFA)
+}
+
+func parseToken(keys *KeySet, tokenString, purpose string) (*Claims, error) {
+	audience, typ := AudienceAPI, TypeAccess
+	if purpose == PurposeMFA {
+		audience, typ = AudienceMFA, TypeMFA
+	}
+
+	parsed, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.keyfunc,
+		jwt.WithValidMethods([]string{AlgEdDSA, AlgES256}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(audience),
+	)
 	if err != nil {
 		return nil, err
 	}
+	if t, _ := parsed.Header["typ"].(string); t != typ {
+		return nil, ErrWrongPurpose
+	}
 	if claims, ok := parsed.Claims.(*Claims); ok && parsed.Valid {
+		if claims.Purpose != purpose {
+			return nil, ErrWrongPurpose
+		}
 		return claims, nil
 	}
 	return nil, jwt.ErrTokenInvalidClaims
@@ -0,0 +1,264 @@
+// Package cbor — минимальный кодек CBOR (RFC 8949), достаточный для WebAuthn:
+// целые числа, байтовые и текстовые строки, массивы, отображения, true/false/null.
+// Числа с плавающей точкой, теги и строки неопределённой длины не поддерживаются.
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorSimple = 7
+)
+
+// maxDepth ограничивает вложенность, чтобы вредоносный ввод не исчерпал стек.
+const maxDepth = 16
+
+var ErrUnexpectedEnd = errors.New("cbor: unexpected end of data")
+
+// Pair — элемент упорядоченного отображения для Encode.
+type Pair struct {
+	Key   any
+	Value any
+}
+
+// Map — отображение с заданным порядком ключей; Encode пишет его в каноническом порядке.
+type Map []Pair
+
+// Decode разбирает один элемент из начала data и возвращает его вместе с неразобранным остатком.
+// Целые числа возвращаются как int64, байтовые строки — []byte, текст — string,
+// массивы — []any, отображения — map[any]any.
+func Decode(data []byte) (any, []byte, error) {
+	return decode(data, 0)
+}
+
+func decode(data []byte, depth int) (any, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+	if len(data) == 0 {
+		return nil, nil, ErrUnexpectedEnd
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == majorSimple {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	arg, data, err := readArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case majorUint:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), data, nil
+	case majorNegInt:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), data, nil
+	case majorBytes, majorText:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrUnexpectedEnd
+		}
+		value, rest := data[:arg], data[arg:]
+		if major == majorText {
+			return string(value), rest, nil
+		}
+		return append([]byte(nil), value...), rest, nil
+	case majorArray:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrUnexpectedEnd
+		}
+		items := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item any
+			item, data, err = decode(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case majorMap:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrUnexpectedEnd
+		}
+		items := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value any
+			key, data, err = decode(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key type")
+			}
+			value, data, err = decode(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, dup := items[key]; dup {
+				return nil, nil, errors.New("cbor: duplicate map key")
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+	}
+}
+
+func readArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, ErrUnexpectedEnd
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, ErrUnexpectedEnd
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, ErrUnexpectedEnd
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, ErrUnexpectedEnd
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, fmt.Errorf("cbor: unsupported additional info %d", info)
+	}
+}
+
+// Encode кодирует значение; поддерживаются int, int64, uint64, []byte, string, bool, nil, []any и Map.
+func Encode(value any) ([]byte, error) {
+	return encode(nil, value)
+}
+
+func encode(out []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(out, 0xf6), nil
+	case bool:
+		if v {
+			return append(out, 0xf5), nil
+		}
+		return append(out, 0xf4), nil
+	case int:
+		return encodeInt(out, int64(v)), nil
+	case int64:
+		return encodeInt(out, v), nil
+	case uint64:
+		return appendHead(out, majorUint, v), nil
+	case []byte:
+		out = appendHead(out, majorBytes, uint64(len(v)))
+		return append(out, v...), nil
+	case string:
+		out = appendHead(out, majorText, uint64(len(v)))
+		return append(out, v...), nil
+	case []any:
+		out = appendHead(out, majorArray, uint64(len(v)))
+		var err error
+		for _, item := range v {
+			if out, err = encode(out, item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case Map:
+		return encodeMap(out, v)
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type %T", value)
+	}
+}
+
+// encodeMap пишет ключи в каноническом порядке CTAP2: сначала по длине кодировки, затем побайтово.
+func encodeMap(out []byte, m Map) ([]byte, error) {
+	type entry struct {
+		key   []byte
+		value any
+	}
+	entries := make([]entry, 0, len(m))
+	for _, pair := range m {
+		key, err := encode(nil, pair.Key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key: key, value: pair.Value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].key, entries[j].key
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return string(a) < string(b)
+	})
+
+	out = appendHead(out, majorMap, uint64(len(entries)))
+	var err error
+	for _, e := range entries {
+		out = append(out, e.key...)
+		if out, err = encode(out, e.value); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func encodeInt(out []byte, v int64) []byte {
+	if v < 0 {
+		return appendHead(out, majorNegInt, uint64(-1-v))
+	}
+	return appendHead(out, majorUint, uint64(v))
+}
+
+func appendHead(out []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(out, major<<5|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(out, major<<5|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(out, major<<5|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(out, major<<5|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(out, major<<5|27), arg)
+	}
+}
@@ -0,0 +1,228 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Примеры из RFC 8949, приложение A.
+func TestDecodeRFCExamples(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want any
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"40", []byte(nil)}, // пустая байтовая строка декодируется в nil
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6449455446", "IETF"},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"80", []any{}},
+		{"83010203", []any{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+	}
+	for _, tt := range tests {
+		got, rest, err := Decode(mustHex(t, tt.hex))
+		if err != nil {
+			t.Errorf("Decode(%s): %v", tt.hex, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("Decode(%s) left %x", tt.hex, rest)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%s) = %#v, want %#v", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeReturnsRest(t *testing.T) {
+	got, rest, err := Decode([]byte{0x01, 0x02, 0x03})
+	if err != nil || got != int64(1) || !bytes.Equal(rest, []byte{0x02, 0x03}) {
+		t.Errorf("Decode = %v, %x, %v", got, rest, err)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	value := Map{
+		{Key: "fmt", Value: "none"},
+		{Key: int64(-2), Value: []byte{1, 2, 3}},
+		{Key: 1, Value: []any{true, false, nil, int64(math.MinInt64), uint64(math.MaxInt64)}},
+		{Key: "attStmt", Value: Map{}},
+	}
+	encoded, err := Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, rest, err := Decode(encoded)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("Decode = %v, %x, %v", decoded, rest, err)
+	}
+	want := map[any]any{
+		"fmt":     "none",
+		int64(-2): []byte{1, 2, 3},
+		int64(1):  []any{true, false, nil, int64(math.MinInt64), int64(math.MaxInt64)},
+		"attStmt": map[any]any{},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("round trip = %#v, want %#v", decoded, want)
+	}
+}
+
+func TestEncodeCanonicalOrder(t *testing.T) {
+	// Порядок CTAP2: короче кодировка — раньше, затем побайтово.
+	encoded, err := Encode(Map{
+		{Key: "bb", Value: 1},
+		{Key: -1, Value: 2},
+		{Key: "a", Value: 3},
+		{Key: 1, Value: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mustHex(t, "a4 01 04 20 02 6161 03 626262 01")
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("Encode = %x, want %x", encoded, want)
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	for _, value := range []any{1.5, map[string]any{}, struct{}{}, []any{1.5}, Map{{Key: 1, Value: 1.5}}} {
+		if _, err := Encode(value); err == nil {
+			t.Errorf("Encode(%#v) accepted", value)
+		}
+	}
+}
+
+// Каждый строгий префикс корректного значения должен давать ошибку, а не панику.
+func TestDecodeTruncated(t *testing.T) {
+	encoded, err := Encode(Map{
+		{Key: "authData", Value: bytes.Repeat([]byte{0xab}, 300)},
+		{Key: "fmt", Value: "none"},
+		{Key: -3, Value: []any{int64(70000), int64(-5000000000), "text", Map{{Key: 1, Value: true}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(encoded); n++ {
+		if got, _, err := Decode(encoded[:n]); err == nil {
+			t.Fatalf("Decode of %d/%d bytes = %#v, want error", n, len(encoded), got)
+		}
+	}
+	if _, rest, err := Decode(encoded); err != nil || len(rest) != 0 {
+		t.Fatalf("Decode of full value: %x, %v", rest, err)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"empty", ""},
+		{"truncated uint8 argument", "18"},
+		{"truncated uint16 argument", "19 01"},
+		{"truncated uint32 argument", "1a 0102 03"},
+		{"truncated uint64 argument", "1b 0102 0304 0506 07"},
+		{"reserved additional info", "1c"},
+		{"indefinite byte string", "5f 41 01 ff"},
+		{"indefinite array", "9f 01 ff"},
+		{"uint overflow", "1b 8000 0000 0000 0000"},
+		{"negative overflow", "3b 8000 0000 0000 0000"},
+		{"negative 2^64", "3b ffff ffff ffff ffff"},
+		{"tag", "c0 60"},
+		{"float", "f9 3c00"},
+		{"undefined simple value", "f0"},
+		{"break outside indefinite", "ff"},
+		{"unsupported map key", "a1 40 01"},
+		{"array map key", "a1 80 01"},
+		{"duplicate map key", "a2 01 01 01 02"},
+		{"map missing value", "a1 01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, err := Decode(mustHex(t, tt.hex)); err == nil {
+				t.Errorf("Decode(%s) = %#v, want error", tt.hex, got)
+			}
+		})
+	}
+}
+
+// Длины из заголовка недоверенные: огромное значение не должно приводить к выделению памяти
+// или панике, только к ErrUnexpectedEnd.
+func TestDecodeOversizedLength(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"byte string longer than data", "44 0102"},
+		{"byte string of 2^32-1 bytes", "5a ffff ffff 00"},
+		{"byte string of 2^64-1 bytes", "5b ffff ffff ffff ffff 00"},
+		{"text of 2^64-1 bytes", "7b ffff ffff ffff ffff 61"},
+		{"array of 2^64-1 items", "9b ffff ffff ffff ffff 01"},
+		{"array of 2^32-1 items", "9a ffff ffff 01 02"},
+		{"map of 2^64-1 pairs", "bb ffff ffff ffff ffff 01 01"},
+		{"array longer than data", "85 01 02"},
+		{"map longer than data", "a3 01 02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Decode(mustHex(t, tt.hex)); !errors.Is(err, ErrUnexpectedEnd) {
+				t.Errorf("Decode(%s) error = %v, want ErrUnexpectedEnd", tt.hex, err)
+			}
+		})
+	}
+}
+
+func TestDecodeNestingLimit(t *testing.T) {
+	// maxDepth вложенных массивов ещё допустимы, на один больше — уже нет.
+	ok := append(bytes.Repeat([]byte{0x81}, maxDepth), 0x00)
+	if _, _, err := Decode(ok); err != nil {
+		t.Errorf("Decode of depth %d: %v", maxDepth, err)
+	}
+	deep := append(bytes.Repeat([]byte{0x81}, maxDepth+1), 0x00)
+	if _, _, err := Decode(deep); err == nil {
+		t.Errorf("Decode of depth %d accepted", maxDepth+1)
+	}
+	huge := append(bytes.Repeat([]byte{0x81}, 100000), 0x00)
+	if _, _, err := Decode(huge); err == nil {
+		t.Error("Decode of 100000 nested arrays accepted")
+	}
+}
+
+func TestDecodeCopiesByteStrings(t *testing.T) {
+	data := []byte{0x42, 0x01, 0x02}
+	got, _, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[1] = 0xff
+	if !bytes.Equal(got.([]byte), []byte{0x01, 0x02}) {
+		t.Error("decoded byte string aliases the input")
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"passkeys/internal/middleware"
+)
+
+const errAccountPendingDeletion = "account scheduled for deletion"
+
+type deleteAccountResponse struct {
+	DeleteAfter time.Time `json:"deleteAfter"`
+}
+
+// DeleteAccount удаляет аккаунт после повторного ввода мастер-пароля. С льготным периодом
+// аккаунт только отключается (все сессии завершаются), а удаляет его PurgeDeletedAccounts.
+func (h *AuthHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req reauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !h.requireReauth(w, r, user.ID, req) {
+		return
+	}
+
+	ctx := r.Context()
+	if h.AccountDeletionGracePeriod <= 0 {
+		if _, err := h.endSessions(ctx, "user_id=$1", user.ID); err != nil {
+			log.Printf("[DeleteAccount] db error (endSessions): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := h.DB.Exec(ctx, "delete from users where id=$1", user.ID); err != nil {
+			log.Printf("[DeleteAccount] db error (delete user): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var deleteAfter time.Time
+	if err := h.DB.QueryRow(ctx,
+		"update users set delete_after=now() + make_interval(secs => $1) where id=$2 returning delete_after",
+		h.AccountDeletionGracePeriod.Seconds(), user.ID,
+	).Scan(&deleteAfter); err != nil {
+		log.Printf("[DeleteAccount] db error (schedule): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.endSessions(ctx, "user_id=$1", user.ID); err != nil {
+		log.Printf("[DeleteAccount] db error (endSessions): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	h.logSecurityEvent(ctx, r, user.ID, eventAccountDeletionRequested, "delete_after="+deleteAfter.Format(time.RFC3339))
+
+	respondJSON(w, deleteAccountResponse{DeleteAfter: deleteAfter})
+}
+
+// RestoreAccount отменяет запрошенное удаление по email и мастер-паролю. Токены не выдаются:
+// после восстановления клиент входит обычным образом, со вторым фактором, если он включён.
+func (h *AuthHandler) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	var req authRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	authHash, err := decodeAuthHash(req.AuthHash)
+	if req.Email == "" || err != nil {
+		http.Error(w, "invalid credentials", http.StatusBadRequest)
+		return
+	}
+
+	throttleKeys := []string{ipThrottleKey(r), emailThrottleKey(req.Email)}
+	if h.throttled(w, r, throttleKeys...) {
+		return
+	}
+
+	ctx := r.Context()
+	user, err := h.findUser(ctx, req.Email)
+	if err != nil || !h.checkSecret(user, authHash, req.Password) {
+		h.recordFailure(w, r, throttleKeys...)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	h.resetFailures(r, emailThrottleKey(req.Email))
+
+	if user.DeleteAfter == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	commandTag, err := h.DB.Exec(ctx, "update users set delete_after=null where id=$1 and delete_after>now()", user.ID)
+	if err != nil {
+		log.Printf("[RestoreAccount] db error (restore): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	// Льготный период истёк, а фоновая задача ещё не дошла до аккаунта.
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "account deleted", http.StatusGone)
+		return
+	}
+	h.logSecurityEvent(ctx, r, user.ID, eventAccountDeletionCancelled, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeDeletedAccounts удаляет пользователей, чей льготный период истёк; записи, заметки,
+// сессии и вторые факторы удаляются каскадом.
+func (h *AuthHandler) PurgeDeletedAccounts(ctx context.Context) error {
+	commandTag, err := h.DB.Exec(ctx, "delete from users where delete_after<=now()")
+	if err != nil {
+		return err
+	}
+	if n := commandTag.RowsAffected(); n > 0 {
+		log.Printf("[PurgeDeletedAccounts] deleted %d accounts", n)
+	}
+	return nil
+}
@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -12,21 +13,25 @@ import (
 	"passkeys/internal/middleware"
 )
 
+// AccountHandler — представление /accounts над записями типа login.
 type AccountHandler struct {
 	DB *pgxpool.Pool
 }
 
 type accountRequest struct {
-	URL            string `json:"url"`
-	Label          string `json:"label"`
-	UsernameCipher string `json:"usernameCipher"`
-	UsernameNonce  string `json:"usernameNonce"`
-	PasswordCipher string `json:"passwordCipher"`
-	PasswordNonce  string `json:"passwordNonce"`
+	// FolderID — пустая строка переносит запись в корень; без поля папка не меняется.
+	FolderID       *string `json:"folderId,omitempty"`
+	URL            string  `json:"url"`
+	Label          string  `json:"label"`
+	UsernameCipher string  `json:"usernameCipher"`
+	UsernameNonce  string  `json:"usernameNonce"`
+	PasswordCipher string  `json:"passwordCipher"`
+	PasswordNonce  string  `json:"passwordNonce"`
 }
 
 type accountResponse struct {
 	ID             string    `json:"id"`
+	FolderID       *string   `json:"folderId"`
 	URL            string    `json:"url"`
 	Label          string    `json:"label"`
 	UsernameCipher string    `json:"usernameCipher"`
@@ -44,47 +49,30 @@ func (h *AccountHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := h.DB.Query(r.Context(), `
-		select id, url, label, username_cipher, username_nonce, password_cipher, password_nonce, created_at, updated_at
-		from accounts where user_id=$1 order by updated_at desc`, user.ID)
+	accounts, err := listAccounts(r.Context(), h.DB, user.ID, r.URL.Query().Get("folder"))
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	accounts := make([]accountResponse, 0)
-	for rows.Next() {
-		var item accountResponse
-		var usernameCipher []byte
-		var usernameNonce []byte
-		var passwordCipher []byte
-		var passwordNonce []byte
-		if err := rows.Scan(
-			&item.ID,
-			&item.URL,
-			&item.Label,
-			&usernameCipher,
-			&usernameNonce,
-			&passwordCipher,
-			&passwordNonce,
-			&item.CreatedAt,
-			&item.UpdatedAt,
-		); err != nil {
-			http.Error(w, "db error", http.StatusInternalServerError)
-			return
-		}
-
-		item.UsernameCipher = base64.StdEncoding.EncodeToString(usernameCipher)
-		item.UsernameNonce = base64.StdEncoding.EncodeToString(usernameNonce)
-		item.PasswordCipher = base64.StdEncoding.EncodeToString(passwordCipher)
-		item.PasswordNonce = base64.StdEncoding.EncodeToString(passwordNonce)
-		accounts = append(accounts, item)
-	}
 
 	respondJSON(w, accounts)
 }
 
+// listAccounts возвращает записи пользователя в том виде, в каком их отдаёт API;
+// folder — id папки, folderRoot или пусто (все записи).
+func listAccounts(ctx context.Context, db *pgxpool.Pool, userID, folder string) ([]accountResponse, error) {
+	items, err := listItems(ctx, db, userID, itemFilter{Type: itemTypeLogin, Folder: folder})
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]accountResponse, 0, len(items))
+	for _, item := range items {
+		accounts = append(accounts, accountFromItem(item))
+	}
+	return accounts, nil
+}
+
 func (h *AccountHandler) Create(w http.ResponseWriter, r *http.Request) {
 	user, ok := middleware.GetUser(r)
 	if !ok {
@@ -102,39 +90,23 @@ func (h *AccountHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	usernameCipher, usernameNonce, passwordCipher, passwordNonce, err := decodeAccount(req)
-	if err != nil {
+	item := req.item()
+	if err := item.validate(); err != nil {
 		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	var response accountResponse
-	err = h.DB.QueryRow(r.Context(), `
-		insert into accounts (user_id, url, label, username_cipher, username_nonce, password_cipher, password_nonce)
-		values ($1, $2, $3, $4, $5, $6, $7)
-		returning id, url, label, username_cipher, username_nonce, password_cipher, password_nonce, created_at, updated_at`,
-		user.ID, req.URL, req.Label, usernameCipher, usernameNonce, passwordCipher, passwordNonce,
-	).Scan(
-		&response.ID,
-		&response.URL,
-		&response.Label,
-		&usernameCipher,
-		&usernameNonce,
-		&passwordCipher,
-		&passwordNonce,
-		&response.CreatedAt,
-		&response.UpdatedAt,
-	)
+	created, err := createItem(r.Context(), h.DB, user.ID, item)
+	if errors.Is(err, errUnknownFolder) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
 
-	response.UsernameCipher = base64.StdEncoding.EncodeToString(usernameCipher)
-	response.UsernameNonce = base64.StdEncoding.EncodeToString(usernameNonce)
-	response.PasswordCipher = base64.StdEncoding.EncodeToString(passwordCipher)
-	response.PasswordNonce = base64.StdEncoding.EncodeToString(passwordNonce)
-	respondJSON(w, response)
+	respondJSON(w, accountFromItem(created))
 }
 
 func (h *AccountHandler) Update(w http.ResponseWriter, r *http.Request) {
@@ -156,42 +128,26 @@ func (h *AccountHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	usernameCipher, usernameNonce, passwordCipher, passwordNonce, err := decodeAccount(req)
-	if err != nil {
+	item := req.item()
+	if err := item.validate(); err != nil {
 		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	var response accountResponse
-	err = h.DB.QueryRow(r.Context(), `
-		update accounts
-		set url=$1, label=$2, username_cipher=$3, username_nonce=$4, password_cipher=$5, password_nonce=$6, updated_at=now()
-		where id=$7 and user_id=$8
-		returning id, url, label, username_cipher, username_nonce, password_cipher, password_nonce, created_at, updated_at`,
-		req.URL, req.Label, usernameCipher, usernameNonce, passwordCipher, passwordNonce, accountID, user.ID,
-	).Scan(
-		&response.ID,
-		&response.URL,
-		&response.Label,
-		&usernameCipher,
-		&usernameNonce,
-		&passwordCipher,
-		&passwordNonce,
-		&response.CreatedAt,
-		&response.UpdatedAt,
-	)
+	updated, err := mergeItem(r.Context(), h.DB, user.ID, accountID, item)
+	if errors.Is(err, errUnknownFolder) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
 
-	response.UsernameCipher = base64.StdEncoding.EncodeToString(usernameCipher)
-	response.UsernameNonce = base64.StdEncoding.EncodeToString(usernameNonce)
-	response.PasswordCipher = base64.StdEncoding.EncodeToString(passwordCipher)
-	response.PasswordNonce = base64.StdEncoding.EncodeToString(passwordNonce)
-	respondJSON(w, response)
+	respondJSON(w, accountFromItem(updated))
 }
 
+// Delete переносит аккаунт в корзину.
 func (h *AccountHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	user, ok := middleware.GetUser(r)
 	if !ok {
@@ -205,12 +161,12 @@ func (h *AccountHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	commandTag, err := h.DB.Exec(r.Context(), "delete from accounts where id=$1 and user_id=$2", accountID, user.ID)
+	deleted, err := trashItem(r.Context(), h.DB, user.ID, accountID, itemTypeLogin)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
-	if commandTag.RowsAffected() == 0 {
+	if !deleted {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
@@ -218,22 +174,30 @@ func (h *AccountHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func decodeAccount(req accountRequest) ([]byte, []byte, []byte, []byte, error) {
-	usernameCipher, err := base64.StdEncoding.DecodeString(req.UsernameCipher)
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	usernameNonce, err := base64.StdEncoding.DecodeString(req.UsernameNonce)
-	if err != nil {
-		return nil, nil, nil, nil, err
+// item переводит аккаунт в запись типа login: url и label — открытые метаданные.
+func (req accountRequest) item() itemRequest {
+	return itemRequest{
+		Type:     itemTypeLogin,
+		FolderID: req.FolderID,
+		Metadata: map[string]string{"url": req.URL, "label": req.Label},
+		Payload: itemPayload{
+			"username": {Cipher: req.UsernameCipher, Nonce: req.UsernameNonce},
+			"password": {Cipher: req.PasswordCipher, Nonce: req.PasswordNonce},
+		},
 	}
-	passwordCipher, err := base64.StdEncoding.DecodeString(req.PasswordCipher)
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	passwordNonce, err := base64.StdEncoding.DecodeString(req.PasswordNonce)
-	if err != nil {
-		return nil, nil, nil, nil, err
+}
+
+func accountFromItem(item itemResponse) accountResponse {
+	return accountResponse{
+		ID:             item.ID,
+		FolderID:       item.FolderID,
+		URL:            item.Metadata["url"],
+		Label:          item.Metadata["label"],
+		UsernameCipher: item.Payload["username"].Cipher,
+		UsernameNonce:  item.Payload["username"].Nonce,
+		PasswordCipher: item.Payload["password"].Cipher,
+		PasswordNonce:  item.Payload["password"].Nonce,
+		CreatedAt:      item.CreatedAt,
+		UpdatedAt:      item.UpdatedAt,
 	}
-	return usernameCipher, usernameNonce, passwordCipher, passwordNonce, nil
 }
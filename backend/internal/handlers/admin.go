@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"passkeys/internal/middleware"
+)
+
+const errAccountDisabled = "account disabled"
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+// Администрирование экземпляра: маршруты /admin закрыты middleware.RequireAdmin.
+// Администратор видит только метаданные — содержимое хранилищ зашифровано на клиенте.
+
+type adminUserResponse struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	Role          string     `json:"role"`
+	EmailVerified bool       `json:"emailVerified"`
+	DisabledAt    *time.Time `json:"disabledAt"`
+	DeleteAfter   *time.Time `json:"deleteAfter"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastSeenAt    *time.Time `json:"lastSeenAt"`
+	Sessions      int        `json:"sessions"`
+	Accounts      int        `json:"accounts"`
+	Notes         int        `json:"notes"`
+	// Items — все записи хранилища, включая аккаунты и заметки; корзина не учитывается.
+	Items int `json:"items"`
+}
+
+type adminUserListResponse struct {
+	Users []adminUserResponse `json:"users"`
+	Total int                 `json:"total"`
+}
+
+type adminLogoutResponse struct {
+	Sessions int `json:"sessions"`
+}
+
+const adminUserColumns = `
+	u.id, u.email, u.role, u.email_verified_at is not null, u.disabled_at, u.delete_after, u.created_at,
+	(select max(last_used_at) from sessions where user_id=u.id),
+	(select count(*) from sessions where user_id=u.id),
+	(select count(*) from items where user_id=u.id and type='login' and deleted_at is null),
+	(select count(*) from items where user_id=u.id and type='note' and deleted_at is null),
+	(select count(*) from items where user_id=u.id and deleted_at is null)`
+
+// AdminListUsers — список пользователей с числом записей. Параметры: q (часть email), limit, offset.
+func (h *AuthHandler) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultAdminPageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAdminPageSize {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	// Символы шаблона LIKE в поиске экранируются.
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSpace(query.Get("q"))) + "%"
+
+	ctx := r.Context()
+	var total int
+	if err := h.DB.QueryRow(ctx, "select count(*) from users where email ilike $1", pattern).Scan(&total); err != nil {
+		log.Printf("[AdminListUsers] db error (count): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.Query(ctx, "select "+adminUserColumns+`
+		from users u where u.email ilike $1
+		order by u.created_at desc limit $2 offset $3`, pattern, limit, offset)
+	if err != nil {
+		log.Printf("[AdminListUsers] db error (select): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := make([]adminUserResponse, 0)
+	for rows.Next() {
+		item, err := scanAdminUser(rows)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		users = append(users, item)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, adminUserListResponse{Users: users, Total: total})
+}
+
+func (h *AuthHandler) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+	if userID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	item, err := scanAdminUser(h.DB.QueryRow(r.Context(), "select "+adminUserColumns+" from users u where u.id::text=$1", userID))
+	if err != nil {
+		adminLookupError(w, err)
+		return
+	}
+
+	respondJSON(w, item)
+}
+
+// AdminDisableUser отключает аккаунт: все сессии завершаются, вход и персональные токены
+// перестают работать. Данные сохраняются, AdminEnableUser возвращает доступ.
+func (h *AuthHandler) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := chi.URLParam(r, "id")
+	if userID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	if userID == admin.ID {
+		http.Error(w, "cannot disable yourself", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var targetID string
+	err := h.DB.QueryRow(ctx,
+		"update users set disabled_at=coalesce(disabled_at, now()) where id::text=$1 returning id", userID,
+	).Scan(&targetID)
+	if err != nil {
+		adminLookupError(w, err)
+		return
+	}
+
+	if _, err := h.endSessions(ctx, "user_id=$1", targetID); err != nil {
+		log.Printf("[AdminDisableUser] db error (endSessions): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	h.logSecurityEvent(ctx, r, targetID, eventAccountDisabled, "admin="+admin.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := chi.URLParam(r, "id")
+	if userID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var targetID string
+	err := h.DB.QueryRow(ctx, "update users set disabled_at=null where id::text=$1 returning id", userID).Scan(&targetID)
+	if err != nil {
+		adminLookupError(w, err)
+		return
+	}
+	h.logSecurityEvent(ctx, r, targetID, eventAccountEnabled, "admin="+admin.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminLogoutUser завершает все сессии пользователя; их refresh-токены удаляются каскадом,
+// а access-токены сразу отзываются через кэш сессий.
+func (h *AuthHandler) AdminLogoutUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := chi.URLParam(r, "id")
+	if userID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var targetID string
+	if err := h.DB.QueryRow(ctx, "select id from users where id::text=$1", userID).Scan(&targetID); err != nil {
+		adminLookupError(w, err)
+		return
+	}
+
+	count, err := h.endSessions(ctx, "user_id=$1", targetID)
+	if err != nil {
+		log.Printf("[AdminLogoutUser] db error (endSessions): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	h.logSecurityEvent(ctx, r, targetID, eventForcedLogout, "admin="+admin.Email+" sessions="+strconv.Itoa(count))
+
+	respondJSON(w, adminLogoutResponse{Sessions: count})
+}
+
+// adminLookupError отвечает 404, если пользователя нет, и 500 на прочие ошибки.
+func adminLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[admin] db error: %v", err)
+	http.Error(w, "db error", http.StatusInternalServerError)
+}
+
+func scanAdminUser(row pgx.Row) (adminUserResponse, error) {
+	var item adminUserResponse
+	err := row.Scan(
+		&item.ID,
+		&item.Email,
+		&item.Role,
+		&item.EmailVerified,
+		&item.DisabledAt,
+		&item.DeleteAfter,
+		&item.CreatedAt,
+		&item.LastSeenAt,
+		&item.Sessions,
+		&item.Accounts,
+		&item.Notes,
+		&item.Items,
+	)
+	return item, err
+}
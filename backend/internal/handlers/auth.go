@@ -2,33 +2,94 @@ package handlers
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"passkeys/internal/auth"
+	"passkeys/internal/mail"
 	"passkeys/internal/middleware"
+	"passkeys/internal/oidc"
+	"passkeys/internal/throttle"
+	"passkeys/internal/webauthn"
 )
 
 type AuthHandler struct {
-	DB                  *pgxpool.Pool
-	Secret              []byte
+	DB     *pgxpool.Pool
+	Secret []byte
+	// Keys подписывает и проверяет JWT; Secret остаётся только для HMAC (фиктивные соли prelogin).
+	Keys                 *auth.KeySet
 	AccessTokenLifetime  time.Duration
 	RefreshTokenLifetime time.Duration
+	// MFAKey шифрует секреты вторых факторов в БД (32 байта).
+	MFAKey   []byte
+	WebAuthn webauthn.Config
+	// Hasher хэширует auth-хэши для users.password_hash.
+	Hasher auth.PasswordHasher
+	// Limiter ограничивает перебор; nil — без ограничений.
+	Limiter *throttle.Limiter
+	Mailer  mail.Mailer
+	// AccountDeletionGracePeriod — сколько удалённый аккаунт можно восстановить; 0 — удалять сразу.
+	AccountDeletionGracePeriod time.Duration
+	// Sessions сразу отзывает access-токены завершённых сессий; nil — токены живут до истечения.
+	Sessions *auth.SessionStore
+	// DeviceVerificationURI — страница, где пользователь вводит код устройства (RFC 8628).
+	DeviceVerificationURI string
+	// OIDC — внешний провайдер для входа через SSO; nil — вход через SSO выключен.
+	OIDC *oidc.Provider
+	// OIDCLinkByEmail — при первом входе привязывать учётную запись провайдера к пользователю
+	// с тем же подтверждённым email. Выключено — только явная привязка из вошедшей сессии.
+	OIDCLinkByEmail bool
 }
 
+// Схемы хранения password_hash в users.auth_scheme.
+const (
+	// authSchemePassword — хэш самого мастер-пароля (пользователи до перехода на auth-хэш).
+	authSchemePassword = "password"
+	// authSchemeAuthHash — хэш auth-хэша, вычисленного на клиенте из мастер-пароля.
+	authSchemeAuthHash = "auth_hash"
+)
+
+const authHashLength = 32
+
+// refreshReuseGracePeriod — окно, в котором повтор ротированного refresh-токена не считается атакой.
+const refreshReuseGracePeriod = 10 * time.Second
+
+var errRefreshTokenInvalid = errors.New("refresh token is invalid, expired or already used")
+
 type authRequest struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	AuthHash string `json:"authHash"`
+	KdfSalt  string `json:"kdfSalt"`
+	kdfParams
+	// VaultKeyCipher/Nonce — ключ хранилища, зашифрованный ключом из мастер-пароля (только при регистрации).
+	// Без них записи шифруются прямо ключом из пароля, как у старых клиентов.
+	VaultKeyCipher string `json:"vaultKeyCipher,omitempty"`
+	VaultKeyNonce  string `json:"vaultKeyNonce,omitempty"`
+	// Password принимается только при входе пользователя со схемой "password",
+	// чтобы один раз перевести его на auth-хэш.
+	Password string `json:"password,omitempty"`
+}
+
+type preloginRequest struct {
+	Email string `json:"email"`
+}
+
+type preloginResponse struct {
+	KdfSalt string `json:"kdfSalt"`
+	kdfParams
+	LegacyAuth bool `json:"legacyAuth"`
 }
 
 type authResponse struct {
@@ -36,6 +97,20 @@ type authResponse struct {
 	RefreshToken string `json:"refreshToken"`
 	Email        string `json:"email"`
 	KdfSalt      string `json:"kdfSalt"`
+	kdfParams
+	EmailVerified bool   `json:"emailVerified"`
+	Role          string `json:"role"`
+	// VaultKeyCipher пуст у хранилищ, зашифрованных прямо ключом из мастер-пароля.
+	VaultKeyCipher  string `json:"vaultKeyCipher,omitempty"`
+	VaultKeyNonce   string `json:"vaultKeyNonce,omitempty"`
+	VaultKeyVersion int    `json:"vaultKeyVersion"`
+}
+
+// reauthRequest — повторное подтверждение мастер-пароля перед чувствительными действиями.
+type reauthRequest struct {
+	AuthHash string `json:"authHash"`
+	// Password — только для пользователей, ещё не перешедших на auth-хэш.
+	Password string `json:"password,omitempty"`
 }
 
 type refreshRequest struct {
@@ -48,12 +123,26 @@ type refreshResponse struct {
 }
 
 type changePasswordRequest struct {
-	CurrentPassword string `json:"currentPassword"`
-	NewPassword     string `json:"newPassword"`
+	CurrentAuthHash string `json:"currentAuthHash"`
+	NewAuthHash     string `json:"newAuthHash"`
+	NewKdfSalt      string `json:"newKdfSalt"`
+	// Параметры KDF для нового пароля; если не переданы — остаются по умолчанию.
+	NewKdfAlgorithm   string `json:"newKdfAlgorithm"`
+	NewKdfIterations  int    `json:"newKdfIterations"`
+	NewKdfMemory      *int   `json:"newKdfMemory,omitempty"`
+	NewKdfParallelism *int   `json:"newKdfParallelism,omitempty"`
+	// CurrentPassword — только для пользователей, ещё не перешедших на auth-хэш.
+	CurrentPassword string `json:"currentPassword,omitempty"`
+	// NewVaultKeyCipher/Nonce — ключ хранилища, зашифрованный ключом нового пароля; обязателен,
+	// если ключ уже есть. Старое хранилище переходит на ключ, передав прежний ключ из пароля.
+	NewVaultKeyCipher string `json:"newVaultKeyCipher,omitempty"`
+	NewVaultKeyNonce  string `json:"newVaultKeyNonce,omitempty"`
 }
 
 type changePasswordResponse struct {
 	KdfSalt string `json:"kdfSalt"`
+	kdfParams
+	VaultKeyVersion int `json:"vaultKeyVersion"`
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -62,11 +151,32 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
-	if req.Email == "" || len(req.Password) < 6 {
+	if req.Email == "" || req.Password != "" {
 		http.Error(w, "invalid credentials", http.StatusBadRequest)
 		return
 	}
 
+	authHash, err := decodeAuthHash(req.AuthHash)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusBadRequest)
+		return
+	}
+	salt, err := decodeKdfSalt(req.KdfSalt)
+	if err != nil {
+		http.Error(w, "invalid kdf salt", http.StatusBadRequest)
+		return
+	}
+	kdf, err := req.kdfParams.normalize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vaultKeyCipher, vaultKeyNonce, err := decodeVaultKey(req.VaultKeyCipher, req.VaultKeyNonce)
+	if err != nil {
+		http.Error(w, "invalid vault key", http.StatusBadRequest)
+		return
+	}
+
 	var exists bool
 	if err := h.DB.QueryRow(r.Context(), "select exists(select 1 from users where email=$1)", req.Email).Scan(&exists); err != nil {
 		log.Printf("[Register] db error (exists check): %v", err)
@@ -78,40 +188,77 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	salt := make([]byte, 16)
-	if _, err := rand.Read(salt); err != nil {
-		http.Error(w, "salt generation failed", http.StatusInternalServerError)
-		return
-	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hash, err := h.Hasher.Hash(authHash)
 	if err != nil {
 		http.Error(w, "hashing failed", http.StatusInternalServerError)
 		return
 	}
 
-	var userID string
+	user := userCredentials{
+		Email:          req.Email,
+		KdfSalt:        salt,
+		AuthScheme:     authSchemeAuthHash,
+		Kdf:            kdf,
+		VaultKeyCipher: vaultKeyCipher,
+		VaultKeyNonce:  vaultKeyNonce,
+	}
+	if vaultKeyCipher != nil {
+		user.VaultKeyVersion = 1
+	}
 	if err := h.DB.QueryRow(r.Context(),
-		"insert into users (email, password_hash, kdf_salt) values ($1, $2, $3) returning id",
-		req.Email, string(hash), salt,
-	).Scan(&userID); err != nil {
+		`insert into users (email, password_hash, kdf_salt, auth_scheme, kdf_algorithm, kdf_iterations, kdf_memory, kdf_parallelism,
+			vault_key_cipher, vault_key_nonce, vault_key_version)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`,
+		req.Email, hash, salt, authSchemeAuthHash, kdf.Algorithm, kdf.Iterations, kdf.Memory, kdf.Parallelism,
+		vaultKeyCipher, vaultKeyNonce, user.VaultKeyVersion,
+	).Scan(&user.ID); err != nil {
 		log.Printf("[Register] db error (insert user): %v", err)
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
 
-	accessToken, refreshToken, err := h.createTokenPair(r.Context(), userID, req.Email)
+	// Письмо с кодом не задерживает регистрацию; при неудаче код можно запросить повторно.
+	if code, err := h.createEmailVerification(r.Context(), user.ID); err != nil {
+		log.Printf("[Register] db error (createEmailVerification): %v", err)
+	} else {
+		h.sendMailInBackground(user.Email, mail.TemplateVerifyEmail, newEmailCodeData(code))
+	}
+
+	h.issueSession(w, r, user)
+}
+
+// Prelogin отдаёт параметры KDF, нужные клиенту, чтобы вычислить auth-хэш до входа.
+// Для неизвестного email возвращается детерминированная фиктивная соль,
+// чтобы по ответу нельзя было перебирать зарегистрированные адреса.
+func (h *AuthHandler) Prelogin(w http.ResponseWriter, r *http.Request) {
+	var req preloginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Email == "" {
+		http.Error(w, "email required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.findUser(r.Context(), req.Email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondJSON(w, preloginResponse{
+			KdfSalt:   base64.StdEncoding.EncodeToString(h.fakeKdfSalt(req.Email)),
+			kdfParams: defaultKdfParams(),
+		})
+		return
+	}
 	if err != nil {
-		log.Printf("[Register] token error (createTokenPair): %v", err)
-		http.Error(w, "token error", http.StatusInternalServerError)
+		log.Printf("[Prelogin] db error (findUser): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(w, authResponse{
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-		Email:        req.Email,
-		KdfSalt:      base64.StdEncoding.EncodeToString(salt),
+	respondJSON(w, preloginResponse{
+		KdfSalt:    base64.StdEncoding.EncodeToString(user.KdfSalt),
+		kdfParams:  user.Kdf,
+		LegacyAuth: user.AuthScheme == authSchemePassword,
 	})
 }
 
@@ -121,35 +268,55 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
-	if req.Email == "" || req.Password == "" {
+	authHash, err := decodeAuthHash(req.AuthHash)
+	if req.Email == "" || err != nil {
 		http.Error(w, "invalid credentials", http.StatusBadRequest)
 		return
 	}
 
+	throttleKeys := []string{ipThrottleKey(r), emailThrottleKey(req.Email)}
+	if h.throttled(w, r, throttleKeys...) {
+		return
+	}
+
 	ctx := r.Context()
-	userID, hash, salt, err := h.findUser(ctx, req.Email)
+	user, err := h.findUser(ctx, req.Email)
 	if err != nil {
+		h.recordFailure(w, r, throttleKeys...)
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+	if (user.AuthScheme != authSchemePassword && req.Password != "") || !h.checkSecret(user, authHash, req.Password) {
+		h.recordFailure(w, r, throttleKeys...)
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	// Счётчик IP не сбрасываем: иначе перебор по многим адресам можно было бы "обнулять"
+	// входом в собственный аккаунт.
+	h.resetFailures(r, emailThrottleKey(req.Email))
 
-	accessToken, refreshToken, err := h.createTokenPair(r.Context(), userID, req.Email)
-	if err != nil {
-		http.Error(w, "token error", http.StatusInternalServerError)
+	if user.DeleteAfter != nil {
+		http.Error(w, errAccountPendingDeletion, http.StatusForbidden)
 		return
 	}
 
-	respondJSON(w, authResponse{
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-		Email:        req.Email,
-		KdfSalt:      base64.StdEncoding.EncodeToString(salt),
-	})
+	if user.Disabled {
+		http.Error(w, errAccountDisabled, http.StatusForbidden)
+		return
+	}
+
+	// Пользователь со старой схемой прислал пароль в последний раз — переводим на auth-хэш.
+	// Хэш bcrypt или с устаревшими параметрами пересчитываем, пока секрет у нас на руках.
+	if user.AuthScheme == authSchemePassword || h.Hasher.NeedsRehash(user.PasswordHash) {
+		if err := h.upgradeToAuthHash(ctx, user.ID, authHash); err != nil {
+			log.Printf("[Login] db error (upgradeToAuthHash): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.issueSessionOrMFA(w, r, user)
 }
 
 func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
@@ -163,13 +330,24 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, email, err := h.validateAndRevokeRefreshToken(r.Context(), req.RefreshToken)
+	ipKey := ipThrottleKey(r)
+	if h.throttled(w, r, ipKey) {
+		return
+	}
+
+	ctx := r.Context()
+	userID, email, sessionID, err := h.validateAndRevokeRefreshToken(ctx, r, req.RefreshToken)
 	if err != nil {
+		h.recordFailure(w, r, ipKey)
 		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
 		return
 	}
 
-	accessToken, refreshToken, err := h.createTokenPair(r.Context(), userID, email)
+	if err := h.touchSession(ctx, r, sessionID); err != nil {
+		log.Printf("[Refresh] db error (touchSession): %v", err)
+	}
+
+	accessToken, refreshToken, err := h.createTokenPair(ctx, userID, email, sessionID)
 	if err != nil {
 		http.Error(w, "token error", http.StatusInternalServerError)
 		return
@@ -193,52 +371,148 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
-	if len(req.NewPassword) < 6 || req.CurrentPassword == "" {
+
+	h.changePassword(w, r, user.ID, req, nil)
+}
+
+// changePassword проверяет текущий мастер-пароль и сохраняет новый. reencrypt (если не nil)
+// выполняется в той же транзакции: пароль и данные хранилища меняются вместе или никак.
+// Ошибка errVaultMismatch из reencrypt означает, что клиент прислал не всё хранилище.
+// Без reencrypt ключ хранилища обычно только перешифровывается ключом нового пароля.
+func (h *AuthHandler) changePassword(w http.ResponseWriter, r *http.Request, userID string, req changePasswordRequest, reencrypt func(ctx context.Context, tx pgx.Tx) error) {
+	newAuthHash, err := decodeAuthHash(req.NewAuthHash)
+	if err != nil {
 		http.Error(w, "invalid credentials", http.StatusBadRequest)
 		return
 	}
+	salt, err := decodeKdfSalt(req.NewKdfSalt)
+	if err != nil {
+		http.Error(w, "invalid kdf salt", http.StatusBadRequest)
+		return
+	}
+	kdf, err := kdfParams{
+		Algorithm:   req.NewKdfAlgorithm,
+		Iterations:  req.NewKdfIterations,
+		Memory:      req.NewKdfMemory,
+		Parallelism: req.NewKdfParallelism,
+	}.normalize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vaultKeyCipher, vaultKeyNonce, err := decodeVaultKey(req.NewVaultKeyCipher, req.NewVaultKeyNonce)
+	if err != nil {
+		http.Error(w, "invalid vault key", http.StatusBadRequest)
+		return
+	}
+	// Для старой схемы currentAuthHash не нужен, поэтому ошибку декодирования здесь не проверяем:
+	// пустой auth-хэш не пройдёт checkSecret.
+	currentAuthHash, _ := decodeAuthHash(req.CurrentAuthHash)
+
+	userKey := userThrottleKey(userID)
+	if h.throttled(w, r, userKey) {
+		return
+	}
 
-	var hash string
-	if err := h.DB.QueryRow(r.Context(), "select password_hash from users where id=$1", user.ID).Scan(&hash); err != nil {
+	ctx := r.Context()
+	creds, err := h.findUserByID(ctx, userID)
+	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
+	// Без нового конверта ключ хранилища не открыть новым паролем.
+	if creds.VaultKeyCipher != nil && vaultKeyCipher == nil {
+		http.Error(w, "vault key required", http.StatusBadRequest)
+		return
+	}
 
-	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)) != nil {
+	if !h.checkSecret(creds, currentAuthHash, req.CurrentPassword) {
+		h.recordFailure(w, r, userKey)
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	h.resetFailures(r, userKey)
 
-	salt := make([]byte, 16)
-	if _, err := rand.Read(salt); err != nil {
-		http.Error(w, "salt generation failed", http.StatusInternalServerError)
+	newHash, err := h.Hasher.Hash(newAuthHash)
+	if err != nil {
+		http.Error(w, "hashing failed", http.StatusInternalServerError)
 		return
 	}
 
-	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	tx, err := h.DB.Begin(ctx)
 	if err != nil {
-		http.Error(w, "hashing failed", http.StatusInternalServerError)
+		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback(ctx)
+
+	// Ключ хранилища меняется, если записи перешифрованы или их ключ выводится прямо из пароля.
+	// Новое поколение ключа — только когда записи перешифрованы новым ключом хранилища.
+	keyChanged := reencrypt != nil || vaultKeyCipher == nil
+	versionStep := 0
+	if reencrypt != nil && vaultKeyCipher != nil {
+		versionStep = 1
+	}
 
-	if _, err := h.DB.Exec(r.Context(),
-		"update users set password_hash=$1, kdf_salt=$2 where id=$3",
-		string(newHash), salt, user.ID,
-	); err != nil {
+	var vaultKeyVersion int
+	if err := tx.QueryRow(ctx,
+		`update users
+		set password_hash=$1, kdf_salt=$2, auth_scheme=$3, kdf_algorithm=$4, kdf_iterations=$5, kdf_memory=$6, kdf_parallelism=$7,
+			vault_key_cipher=coalesce($8, vault_key_cipher), vault_key_nonce=coalesce($9, vault_key_nonce),
+			vault_key_version=vault_key_version+$10
+		where id=$11
+		returning vault_key_version`,
+		newHash, salt, authSchemeAuthHash, kdf.Algorithm, kdf.Iterations, kdf.Memory, kdf.Parallelism,
+		vaultKeyCipher, vaultKeyNonce, versionStep, userID,
+	).Scan(&vaultKeyVersion); err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
 
-	// Инвалидируем все refresh-токены при смене пароля
-	_, _ = h.DB.Exec(r.Context(), "delete from refresh_tokens where user_id=$1", user.ID)
+	// Переданные доверенным контактам копии старого ключа бесполезны: доверенности ждут
+	// нового подтверждения. Если ключ только перешифрован паролем, копии остаются в силе.
+	if keyChanged {
+		if _, err := tx.Exec(ctx, `
+			update emergency_access set status=$1, key_encrypted=null, recovery_initiated_at=null, updated_at=now()
+			where grantor_id=$2 and status<>$3`,
+			emergencyStatusAccepted, userID, emergencyStatusInvited,
+		); err != nil {
+			log.Printf("[ChangePassword] db error (reset emergency access): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if reencrypt != nil {
+		if err := reencrypt(ctx, tx); err != nil {
+			if errors.Is(err, errVaultMismatch) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			log.Printf("[ChangePassword] db error (reencrypt): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	// Завершаем все сессии (вместе с их refresh-токенами) при смене пароля
+	_, _ = h.endSessions(ctx, "user_id=$1", userID)
+	h.notify(creds, mail.TemplatePasswordChanged, newSecurityNoticeData(r))
 
 	respondJSON(w, changePasswordResponse{
-		KdfSalt: base64.StdEncoding.EncodeToString(salt),
+		KdfSalt:         base64.StdEncoding.EncodeToString(salt),
+		kdfParams:       kdf,
+		VaultKeyVersion: vaultKeyVersion,
 	})
 }
 
-func (h *AuthHandler) createTokenPair(ctx context.Context, userID, email string) (accessToken, refreshToken string, err error) {
-	accessToken, err = auth.CreateTokenWithLifetime(h.Secret, userID, email, h.AccessTokenLifetime)
+func (h *AuthHandler) createTokenPair(ctx context.Context, userID, email, sessionID string) (accessToken, refreshToken string, err error) {
+	accessToken, err = auth.CreateTokenWithLifetime(h.Keys, userID, email, sessionID, h.AccessTokenLifetime)
 	if err != nil {
 		return "", "", err
 	}
@@ -248,13 +522,12 @@ func (h *AuthHandler) createTokenPair(ctx context.Context, userID, email string)
 		return "", "", err
 	}
 	refreshToken = base64.URLEncoding.EncodeToString(refreshBytes)
-	tokenHash := sha256.Sum256([]byte(refreshToken))
-	hashHex := hex.EncodeToString(tokenHash[:])
+	hashHex := hashToken(refreshToken)
 	expiresAt := time.Now().Add(h.RefreshTokenLifetime)
 
 	if _, err := h.DB.Exec(ctx,
-		"insert into refresh_tokens (user_id, token_hash, expires_at) values ($1, $2, $3)",
-		userID, hashHex, expiresAt,
+		"insert into refresh_tokens (user_id, session_id, token_hash, expires_at) values ($1, $2, $3, $4)",
+		userID, sessionID, hashHex, expiresAt,
 	); err != nil {
 		return "", "", err
 	}
@@ -262,30 +535,297 @@ func (h *AuthHandler) createTokenPair(ctx context.Context, userID, email string)
 	return accessToken, refreshToken, nil
 }
 
-func (h *AuthHandler) validateAndRevokeRefreshToken(ctx context.Context, token string) (userID, email string, err error) {
-	tokenHash := sha256.Sum256([]byte(token))
-	hashHex := hex.EncodeToString(tokenHash[:])
+// validateAndRevokeRefreshToken погашает предъявленный refresh-токен. Токены сессии образуют
+// семью ротации (OAuth 2.0 Security BCP, §4.14): если предъявлен уже ротированный токен,
+// он утёк, и вся сессия отзывается вместе с действующим токеном.
+func (h *AuthHandler) validateAndRevokeRefreshToken(ctx context.Context, r *http.Request, token string) (userID, email, sessionID string, err error) {
+	hashHex := hashToken(token)
 
 	err = h.DB.QueryRow(ctx,
-		"delete from refresh_tokens where token_hash=$1 and expires_at>now() returning user_id",
+		"update refresh_tokens set revoked_at=now() where token_hash=$1 and revoked_at is null and expires_at>now() returning user_id, session_id",
 		hashHex,
-	).Scan(&userID)
+	).Scan(&userID, &sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.detectRefreshTokenReuse(ctx, r, hashHex)
+		return "", "", "", errRefreshTokenInvalid
+	}
 	if err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 
 	if err := h.DB.QueryRow(ctx, "select email from users where id=$1", userID).Scan(&email); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
-	return userID, email, nil
+	return userID, email, sessionID, nil
+}
+
+// hashToken — sha256 в hex: так в БД хранятся секреты, которые сервер выдаёт сам
+// (refresh-токены, коды восстановления) и сверяет только по хэшу.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type userCredentials struct {
+	ID           string
+	Email        string
+	PasswordHash string
+	KdfSalt      []byte
+	AuthScheme   string
+	Kdf          kdfParams
+	// DeleteAfter задан, если пользователь запросил удаление: до этого момента аккаунт отключён.
+	DeleteAfter   *time.Time
+	EmailVerified bool
+	Role          string
+	// Disabled — аккаунт отключён администратором: вход запрещён.
+	Disabled bool
+	// VaultKeyCipher — ключ хранилища, зашифрованный ключом из мастер-пароля; nil — старое хранилище.
+	VaultKeyCipher  []byte
+	VaultKeyNonce   []byte
+	VaultKeyVersion int
+}
+
+const userCredentialsColumns = "id, email, password_hash, kdf_salt, auth_scheme, kdf_algorithm, kdf_iterations, kdf_memory, kdf_parallelism, delete_after, email_verified_at is not null, role, disabled_at is not null, vault_key_cipher, vault_key_nonce, vault_key_version"
+
+func scanUserCredentials(row pgx.Row) (userCredentials, error) {
+	var user userCredentials
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.PasswordHash,
+		&user.KdfSalt,
+		&user.AuthScheme,
+		&user.Kdf.Algorithm,
+		&user.Kdf.Iterations,
+		&user.Kdf.Memory,
+		&user.Kdf.Parallelism,
+		&user.DeleteAfter,
+		&user.EmailVerified,
+		&user.Role,
+		&user.Disabled,
+		&user.VaultKeyCipher,
+		&user.VaultKeyNonce,
+		&user.VaultKeyVersion,
+	)
+	return user, err
 }
 
-func (h *AuthHandler) findUser(ctx context.Context, email string) (string, string, []byte, error) {
-	var userID string
-	var hash string
-	var salt []byte
-	err := h.DB.QueryRow(ctx, "select id, password_hash, kdf_salt from users where email=$1", email).Scan(&userID, &hash, &salt)
-	return userID, hash, salt, err
+// detectRefreshTokenReuse отзывает семью, если предъявленный токен уже был ротирован.
+// Повтор в пределах refreshReuseGracePeriod считается гонкой параллельных запросов
+// одного клиента: запрос отклоняется, но сессия остаётся.
+func (h *AuthHandler) detectRefreshTokenReuse(ctx context.Context, r *http.Request, hashHex string) {
+	var userID, sessionID string
+	var revokedAt *time.Time
+	err := h.DB.QueryRow(ctx,
+		"select user_id, session_id, revoked_at from refresh_tokens where token_hash=$1",
+		hashHex,
+	).Scan(&userID, &sessionID, &revokedAt)
+	if err != nil || revokedAt == nil || time.Since(*revokedAt) < refreshReuseGracePeriod {
+		return
+	}
+
+	if _, err := h.endSessions(ctx, "id=$1", sessionID); err != nil {
+		log.Printf("[Refresh] db error (revoke family): %v", err)
+	}
+	h.logSecurityEvent(ctx, r, userID, eventRefreshTokenReuse, "session="+sessionID)
+}
+
+// PurgeExpiredRefreshTokens удаляет истёкшие refresh-токены, в том числе ротированные,
+// которые хранились только для обнаружения повторного использования.
+func (h *AuthHandler) PurgeExpiredRefreshTokens(ctx context.Context) error {
+	_, err := h.DB.Exec(ctx, "delete from refresh_tokens where expires_at<now()")
+	return err
+}
+
+func (h *AuthHandler) findUser(ctx context.Context, email string) (userCredentials, error) {
+	return scanUserCredentials(h.DB.QueryRow(ctx, "select "+userCredentialsColumns+" from users where email=$1", email))
+}
+
+func (h *AuthHandler) findUserByID(ctx context.Context, userID string) (userCredentials, error) {
+	return scanUserCredentials(h.DB.QueryRow(ctx, "select "+userCredentialsColumns+" from users where id=$1", userID))
+}
+
+// issueSessionOrMFA завершает вход по первому фактору: при подключённой 2FA отвечает
+// mfaRequiredResponse, и сессию выдаст LoginMFA, иначе сразу issueSession.
+func (h *AuthHandler) issueSessionOrMFA(w http.ResponseWriter, r *http.Request, user userCredentials) {
+	methods, err := h.mfaMethods(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("[issueSessionOrMFA] db error (mfaMethods): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if len(methods) > 0 {
+		mfaToken, err := auth.CreateMFAToken(h.Keys, user.ID, user.Email)
+		if err != nil {
+			http.Error(w, "token error", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, mfaRequiredResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			Methods:     methods,
+		})
+		return
+	}
+
+	h.issueSession(w, r, user)
+}
+
+// issueSession открывает новую сессию, выдаёт пару токенов и отвечает authResponse —
+// общий финал регистрации и всех способов входа.
+func (h *AuthHandler) issueSession(w http.ResponseWriter, r *http.Request, user userCredentials) {
+	// Все пути входа (пароль, второй фактор, passkey) проходят здесь.
+	if user.DeleteAfter != nil {
+		http.Error(w, errAccountPendingDeletion, http.StatusForbidden)
+		return
+	}
+	if user.Disabled {
+		http.Error(w, errAccountDisabled, http.StatusForbidden)
+		return
+	}
+
+	sessionID, err := h.startSession(r.Context(), r, user.ID)
+	if err != nil {
+		log.Printf("[issueSession] db error (startSession): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, refreshToken, err := h.createTokenPair(r.Context(), user.ID, user.Email, sessionID)
+	if err != nil {
+		log.Printf("[issueSession] token error (createTokenPair): %v", err)
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	newDevice, err := h.rememberDevice(r.Context(), r, user.ID)
+	if err != nil {
+		log.Printf("[issueSession] db error (rememberDevice): %v", err)
+	}
+	if newDevice {
+		h.notify(user, mail.TemplateNewDeviceLogin, newSecurityNoticeData(r))
+	}
+
+	resp := authResponse{
+		Token:           accessToken,
+		RefreshToken:    refreshToken,
+		Email:           user.Email,
+		KdfSalt:         base64.StdEncoding.EncodeToString(user.KdfSalt),
+		kdfParams:       user.Kdf,
+		EmailVerified:   user.EmailVerified,
+		Role:            user.Role,
+		VaultKeyVersion: user.VaultKeyVersion,
+	}
+	if user.VaultKeyCipher != nil {
+		resp.VaultKeyCipher = base64.StdEncoding.EncodeToString(user.VaultKeyCipher)
+		resp.VaultKeyNonce = base64.StdEncoding.EncodeToString(user.VaultKeyNonce)
+	}
+	respondJSON(w, resp)
+}
+
+// requireReauth повторно проверяет мастер-пароль пользователя с активной сессией перед
+// чувствительным действием. При неудаче сам пишет ответ и возвращает false.
+func (h *AuthHandler) requireReauth(w http.ResponseWriter, r *http.Request, userID string, req reauthRequest) bool {
+	key := userThrottleKey(userID)
+	if h.throttled(w, r, key) {
+		return false
+	}
+
+	user, err := h.findUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	// Ошибка декодирования не важна: пустой auth-хэш не пройдёт checkSecret.
+	authHash, _ := decodeAuthHash(req.AuthHash)
+	if !h.checkSecret(user, authHash, req.Password) {
+		h.recordFailure(w, r, key)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return false
+	}
+
+	h.resetFailures(r, key)
+	return true
+}
+
+func (h *AuthHandler) upgradeToAuthHash(ctx context.Context, userID string, authHash []byte) error {
+	hash, err := h.Hasher.Hash(authHash)
+	if err != nil {
+		return err
+	}
+	_, err = h.DB.Exec(ctx,
+		"update users set password_hash=$1, auth_scheme=$2 where id=$3",
+		hash, authSchemeAuthHash, userID,
+	)
+	return err
+}
+
+// fakeKdfSalt — стабильная соль для несуществующего email, неотличимая от настоящей.
+func (h *AuthHandler) fakeKdfSalt(email string) []byte {
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write([]byte("prelogin:" + email))
+	return mac.Sum(nil)[:16]
+}
+
+// checkSecret сверяет предъявленный секрет с password_hash с учётом схемы пользователя:
+// для старой схемы — мастер-пароль, для новой — auth-хэш.
+func (h *AuthHandler) checkSecret(user userCredentials, authHash []byte, password string) bool {
+	var secret []byte
+	if user.AuthScheme == authSchemePassword {
+		secret = []byte(password)
+	} else if len(authHash) == authHashLength {
+		secret = authHash
+	}
+	if len(secret) == 0 {
+		return false
+	}
+
+	ok, err := h.Hasher.Verify(user.PasswordHash, secret)
+	if err != nil {
+		log.Printf("[checkSecret] hash error (user %s): %v", user.ID, err)
+	}
+	return ok
+}
+
+func decodeAuthHash(value string) ([]byte, error) {
+	authHash, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(authHash) != authHashLength {
+		return nil, fmt.Errorf("auth hash must be %d bytes", authHashLength)
+	}
+	return authHash, nil
+}
+
+func decodeKdfSalt(value string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(salt) < 16 || len(salt) > 64 {
+		return nil, fmt.Errorf("kdf salt must be 16-64 bytes")
+	}
+	return salt, nil
+}
+
+// decodeVaultKey разбирает зашифрованный ключ хранилища; пустые поля — ключ не передан.
+func decodeVaultKey(cipher, nonce string) ([]byte, []byte, error) {
+	if cipher == "" && nonce == "" {
+		return nil, nil, nil
+	}
+	keyCipher, err := base64.StdEncoding.DecodeString(cipher)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyNonce, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keyCipher) == 0 || len(keyNonce) == 0 {
+		return nil, nil, errors.New("vault key cipher and nonce required")
+	}
+	return keyCipher, keyNonce, nil
 }
 
 func respondJSON(w http.ResponseWriter, payload any) {
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFakeKdfSalt(t *testing.T) {
+	h := &AuthHandler{Secret: []byte("test secret")}
+
+	first := h.fakeKdfSalt("nobody@example.com")
+	if len(first) != 16 {
+		t.Fatalf("salt length = %d, want 16", len(first))
+	}
+	// Повторный prelogin должен видеть ту же соль, иначе несуществующий email легко отличить.
+	if again := h.fakeKdfSalt("nobody@example.com"); !bytes.Equal(first, again) {
+		t.Error("salt changes between calls")
+	}
+	if other := h.fakeKdfSalt("somebody@example.com"); bytes.Equal(first, other) {
+		t.Error("different emails share a salt")
+	}
+	otherSecret := &AuthHandler{Secret: []byte("another secret")}
+	if salt := otherSecret.fakeKdfSalt("nobody@example.com"); bytes.Equal(first, salt) {
+		t.Error("salt does not depend on the server secret")
+	}
+}
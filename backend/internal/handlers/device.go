@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"passkeys/internal/mail"
+	"passkeys/internal/middleware"
+)
+
+// Device Authorization Grant (RFC 8628). Ответы /auth/device/code и /auth/device/token
+// следуют RFC (snake_case, ошибки полем error), чтобы подходили обычные OAuth-клиенты.
+const (
+	deviceCodeLifetime   = 10 * time.Minute
+	devicePollInterval   = 5 * time.Second
+	deviceGrantType      = "urn:ietf:params:oauth:grant-type:device_code"
+	maxDeviceClientIDLen = 100
+	// userCodeAlphabet — согласные без похожих друг на друга букв (RFC 8628, §6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+const (
+	deviceStatusPending  = "pending"
+	deviceStatusApproved = "approved"
+	deviceStatusDenied   = "denied"
+)
+
+type deviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type deviceApprovalRequest struct {
+	UserCode string `json:"userCode"`
+}
+
+type deviceApprovalResponse struct {
+	ClientID  string    `json:"clientId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeviceCode выдаёт устройству device_code и короткий user_code для подтверждения.
+func (h *AuthHandler) DeviceCode(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID := truncate(r.PostForm.Get("client_id"), maxDeviceClientIDLen)
+
+	// Каждый выданный код считается попыткой: иначе таблицу можно забить анонимными запросами.
+	key := deviceCodeThrottleKey(r)
+	if h.throttled(w, r, key) {
+		return
+	}
+	h.recordFailure(w, r, key)
+
+	deviceCodeBytes := make([]byte, 32)
+	if _, err := rand.Read(deviceCodeBytes); err != nil {
+		http.Error(w, "code generation failed", http.StatusInternalServerError)
+		return
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(deviceCodeBytes)
+
+	ctx := r.Context()
+	var userCode string
+	for attempt := 0; ; attempt++ {
+		code, err := generateUserCode()
+		if err != nil {
+			http.Error(w, "code generation failed", http.StatusInternalServerError)
+			return
+		}
+		_, err = h.DB.Exec(ctx, `
+			insert into device_authorizations (device_code_hash, user_code, client_id, poll_interval, expires_at)
+			values ($1, $2, $3, $4, $5)`,
+			hashToken(deviceCode), code, clientID, int(devicePollInterval.Seconds()), time.Now().Add(deviceCodeLifetime),
+		)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			log.Printf("[DeviceCode] db error (insert): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		userCode = code
+		break
+	}
+
+	formatted := formatUserCode(userCode)
+	resp := deviceCodeResponse{
+		DeviceCode:      deviceCode,
+		UserCode:        formatted,
+		VerificationURI: h.DeviceVerificationURI,
+		ExpiresIn:       int(deviceCodeLifetime.Seconds()),
+		Interval:        int(devicePollInterval.Seconds()),
+	}
+	if h.DeviceVerificationURI != "" {
+		resp.VerificationURIComplete = h.DeviceVerificationURI + "?user_code=" + formatted
+	}
+	respondJSON(w, resp)
+}
+
+// DeviceToken — опрос устройством token endpoint. После подтверждения выдаётся обычная
+// пара токенов в новой сессии; device_code после этого недействителен.
+func (h *AuthHandler) DeviceToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("grant_type") != deviceGrantType {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	deviceCode := r.PostForm.Get("device_code")
+	if deviceCode == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		id, status   string
+		clientID     string
+		userID       *string
+		interval     int
+		lastPolledAt *time.Time
+		expiresAt    time.Time
+	)
+	err = tx.QueryRow(ctx, `
+		select id, status, client_id, user_id, poll_interval, last_polled_at, expires_at
+		from device_authorizations where device_code_hash=$1 for update`,
+		hashToken(deviceCode),
+	).Scan(&id, &status, &clientID, &userID, &interval, &lastPolledAt, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if err != nil {
+		log.Printf("[DeviceToken] db error (select): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	if time.Now().After(expiresAt) {
+		writeOAuthError(w, http.StatusBadRequest, "expired_token")
+		return
+	}
+
+	switch status {
+	case deviceStatusDenied:
+		_, _ = tx.Exec(ctx, "delete from device_authorizations where id=$1", id)
+		_ = tx.Commit(ctx)
+		writeOAuthError(w, http.StatusBadRequest, "access_denied")
+		return
+	case deviceStatusPending:
+		// Слишком частый опрос: по RFC интервал увеличивается на 5 секунд.
+		if lastPolledAt != nil && time.Since(*lastPolledAt) < time.Duration(interval)*time.Second {
+			_, _ = tx.Exec(ctx, "update device_authorizations set poll_interval=poll_interval+5, last_polled_at=now() where id=$1", id)
+			_ = tx.Commit(ctx)
+			writeOAuthError(w, http.StatusBadRequest, "slow_down")
+			return
+		}
+		_, _ = tx.Exec(ctx, "update device_authorizations set last_polled_at=now() where id=$1", id)
+		_ = tx.Commit(ctx)
+		writeOAuthError(w, http.StatusBadRequest, "authorization_pending")
+		return
+	}
+
+	if userID == nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if _, err := tx.Exec(ctx, "delete from device_authorizations where id=$1", id); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.findUserByID(ctx, *userID)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if user.DeleteAfter != nil || user.Disabled {
+		writeOAuthError(w, http.StatusBadRequest, "access_denied")
+		return
+	}
+
+	// Сессия называется по client_id, если устройство не передало своё имя.
+	if r.Header.Get(deviceNameHeader) == "" && clientID != "" {
+		r.Header.Set(deviceNameHeader, clientID)
+	}
+	accessToken, refreshToken, err := h.startDeviceSession(ctx, r, user)
+	if err != nil {
+		log.Printf("[DeviceToken] session error: %v", err)
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, deviceTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(h.AccessTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+	})
+}
+
+func (h *AuthHandler) startDeviceSession(ctx context.Context, r *http.Request, user userCredentials) (string, string, error) {
+	sessionID, err := h.startSession(ctx, r, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	accessToken, refreshToken, err := h.createTokenPair(ctx, user.ID, user.Email, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	newDevice, err := h.rememberDevice(ctx, r, user.ID)
+	if err != nil {
+		log.Printf("[DeviceToken] db error (rememberDevice): %v", err)
+	}
+	if newDevice {
+		h.notify(user, mail.TemplateNewDeviceLogin, newSecurityNoticeData(r))
+	}
+	return accessToken, refreshToken, nil
+}
+
+// DeviceLookup показывает пользователю, какое устройство просит доступ, до подтверждения.
+func (h *AuthHandler) DeviceLookup(w http.ResponseWriter, r *http.Request) {
+	h.decideDevice(w, r, "")
+}
+
+// ApproveDevice подтверждает user_code: устройство получит сессию этого пользователя.
+func (h *AuthHandler) ApproveDevice(w http.ResponseWriter, r *http.Request) {
+	h.decideDevice(w, r, deviceStatusApproved)
+}
+
+func (h *AuthHandler) DenyDevice(w http.ResponseWriter, r *http.Request) {
+	h.decideDevice(w, r, deviceStatusDenied)
+}
+
+// decideDevice находит ожидающий запрос по user_code и, если decision не пуст, записывает решение.
+func (h *AuthHandler) decideDevice(w http.ResponseWriter, r *http.Request, decision string) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req deviceApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userKey := userThrottleKey(user.ID)
+	if h.throttled(w, r, userKey) {
+		return
+	}
+
+	ctx := r.Context()
+	var resp deviceApprovalResponse
+	var err error
+	if decision == "" {
+		err = h.DB.QueryRow(ctx, `
+			select client_id, created_at from device_authorizations
+			where user_code=$1 and status=$2 and expires_at>now()`,
+			normalizeUserCode(req.UserCode), deviceStatusPending,
+		).Scan(&resp.ClientID, &resp.CreatedAt)
+	} else {
+		err = h.DB.QueryRow(ctx, `
+			update device_authorizations set status=$1, user_id=$2
+			where user_code=$3 and status=$4 and expires_at>now()
+			returning client_id, created_at`,
+			decision, user.ID, normalizeUserCode(req.UserCode), deviceStatusPending,
+		).Scan(&resp.ClientID, &resp.CreatedAt)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.recordFailure(w, r, userKey)
+		http.Error(w, "invalid code", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[decideDevice] db error: %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, resp)
+}
+
+// PurgeExpiredDeviceCodes удаляет неиспользованные коды устройств.
+func (h *AuthHandler) PurgeExpiredDeviceCodes(ctx context.Context) error {
+	_, err := h.DB.Exec(ctx, "delete from device_authorizations where expires_at<now()")
+	return err
+}
+
+func generateUserCode() (string, error) {
+	buf := make([]byte, userCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := make([]byte, userCodeLength)
+	for i, b := range buf {
+		// 256 не делится на 20, но смещение в пользу первых букв для кода
+		// с коротким сроком жизни несущественно.
+		code[i] = userCodeAlphabet[int(b)%len(userCodeAlphabet)]
+	}
+	return string(code), nil
+}
+
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode допускает ввод в любом регистре, с дефисом и пробелами.
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"passkeys/internal/mail"
+	"passkeys/internal/middleware"
+)
+
+const pgUniqueViolation = "23505"
+
+type changeEmailRequest struct {
+	NewEmail string `json:"newEmail"`
+	reauthRequest
+}
+
+type confirmEmailRequest struct {
+	Code string `json:"code"`
+}
+
+// ChangeEmail начинает смену email: после повторного ввода мастер-пароля на новый адрес
+// уходит код, и адрес меняется только после ConfirmEmailChange.
+func (h *AuthHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req changeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if !strings.Contains(newEmail, "@") || newEmail == user.Email {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+	mailKey := mailThrottleKey(user.ID)
+	if h.throttled(w, r, mailKey) {
+		return
+	}
+	if !h.requireReauth(w, r, user.ID, req.reauthRequest) {
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.DB.QueryRow(ctx, "select exists(select 1 from users where email=$1)", newEmail).Scan(&exists); err != nil {
+		log.Printf("[ChangeEmail] db error (exists check): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "email already exists", http.StatusConflict)
+		return
+	}
+
+	// Код того же вида, что и коды восстановления: его удобно переписать из письма.
+	code, err := generateRecoveryCode()
+	if err != nil {
+		http.Error(w, "code generation failed", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.DB.Exec(ctx, `
+		insert into email_changes (user_id, new_email, token_hash, expires_at) values ($1, $2, $3, $4)
+		on conflict (user_id) do update
+		set new_email=excluded.new_email, token_hash=excluded.token_hash, expires_at=excluded.expires_at, created_at=now()`,
+		user.ID, newEmail, hashToken(normalizeRecoveryCode(code)), time.Now().Add(emailCodeLifetime),
+	); err != nil {
+		log.Printf("[ChangeEmail] db error (insert): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordFailure(w, r, mailKey)
+	if err := h.sendMail(ctx, newEmail, mail.TemplateEmailChange, newEmailCodeData(code)); err != nil {
+		log.Printf("[ChangeEmail] mail error: %v", err)
+		http.Error(w, "mail error", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmEmailChange применяет смену email по коду из письма. Все сессии завершаются,
+// а текущему устройству выдаётся новая сессия с токенами на новый адрес.
+func (h *AuthHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req confirmEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userKey := userThrottleKey(user.ID)
+	if h.throttled(w, r, userKey) {
+		return
+	}
+
+	ctx := r.Context()
+	previous, err := h.findUserByID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var newEmail string
+	err = tx.QueryRow(ctx,
+		"delete from email_changes where user_id=$1 and token_hash=$2 and expires_at>now() returning new_email",
+		user.ID, hashToken(normalizeRecoveryCode(req.Code)),
+	).Scan(&newEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.recordFailure(w, r, userKey)
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[ConfirmEmailChange] db error (consume code): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(ctx, "update users set email=$1, email_verified_at=now() where id=$2", newEmail, user.ID); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			http.Error(w, "email already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("[ConfirmEmailChange] db error (update email): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	h.resetFailures(r, userKey)
+	h.logSecurityEvent(ctx, r, user.ID, eventEmailChanged, "from="+previous.Email+" to="+newEmail)
+
+	// Старый адрес узнаёт о смене: если это не владелец, у него есть шанс заметить.
+	h.notify(previous, mail.TemplateEmailChanged, struct{ NewEmail string }{newEmail})
+
+	// Refresh-токены и access-токены со старым email больше не нужны.
+	if _, err := h.endSessions(ctx, "user_id=$1", user.ID); err != nil {
+		log.Printf("[ConfirmEmailChange] db error (endSessions): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	creds, err := h.findUserByID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	h.issueSession(w, r, creds)
+}
@@ -0,0 +1,534 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"passkeys/internal/mail"
+	"passkeys/internal/middleware"
+)
+
+// Экстренный доступ: владелец хранилища (grantor) приглашает доверенный контакт (grantee).
+// После принятия приглашения grantor шифрует ключ своего хранилища открытым ключом grantee.
+// Grantee может запросить доступ; если grantor не отклонит запрос за wait_days, доступ
+// открывается сам. Сервер видит только зашифрованный ключ и шифротексты.
+const (
+	emergencyStatusInvited           = "invited"
+	emergencyStatusAccepted          = "accepted"
+	emergencyStatusConfirmed         = "confirmed"
+	emergencyStatusRecoveryInitiated = "recovery_initiated"
+	emergencyStatusRecoveryApproved  = "recovery_approved"
+)
+
+const (
+	defaultEmergencyWaitDays = 7
+	maxEmergencyWaitDays     = 90
+	maxEmergencyContacts     = 20
+)
+
+// emergencyStatusColumn — статус с учётом истёкшего периода ожидания: запрос, который
+// grantor не отклонил вовремя, считается одобренным без отдельной фоновой задачи.
+const emergencyStatusColumn = `
+	case when e.status='recovery_initiated' and e.recovery_initiated_at + make_interval(days => e.wait_days) <= now()
+	then 'recovery_approved' else e.status end`
+
+type emergencyInviteRequest struct {
+	Email    string `json:"email"`
+	WaitDays int    `json:"waitDays"`
+}
+
+type emergencyConfirmRequest struct {
+	// KeyEncrypted — ключ хранилища, зашифрованный открытым ключом grantee, в base64.
+	KeyEncrypted string `json:"keyEncrypted"`
+	reauthRequest
+}
+
+type emergencyAccessResponse struct {
+	ID                  string     `json:"id"`
+	GrantorEmail        string     `json:"grantorEmail"`
+	GranteeEmail        string     `json:"granteeEmail"`
+	Status              string     `json:"status"`
+	WaitDays            int        `json:"waitDays"`
+	RecoveryInitiatedAt *time.Time `json:"recoveryInitiatedAt"`
+	// GranteePublicKey нужен grantor для подтверждения; grantee его не получает.
+	GranteePublicKey string    `json:"granteePublicKey,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+type emergencyAccessListResponse struct {
+	// Trusted — кому пользователь доверил своё хранилище; Granted — кто доверил ему своё.
+	Trusted []emergencyAccessResponse `json:"trusted"`
+	Granted []emergencyAccessResponse `json:"granted"`
+}
+
+type emergencyVaultResponse struct {
+	KeyEncrypted string            `json:"keyEncrypted"`
+	Accounts     []accountResponse `json:"accounts"`
+	Notes        []noteResponse    `json:"notes"`
+	// Items — все записи, в том числе уже вошедшие в Accounts и Notes.
+	Items   []itemResponse   `json:"items"`
+	Folders []folderResponse `json:"folders"`
+}
+
+type emergencyAccess struct {
+	ID           string
+	GrantorID    string
+	GranteeID    *string
+	GranteeEmail string
+	Status       string
+	WaitDays     int
+	KeyEncrypted []byte
+}
+
+func (h *AuthHandler) ListEmergencyAccess(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	creds, err := h.findUserByID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	trusted, err := h.queryEmergencyAccess(ctx, true, "e.grantor_id=$1", user.ID)
+	if err != nil {
+		log.Printf("[ListEmergencyAccess] db error (trusted): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	// Приглашения на неподтверждённый адрес не показываем: адрес мог указать кто угодно.
+	granted, err := h.queryEmergencyAccess(ctx, false,
+		"e.grantee_id=$1 or (e.grantee_id is null and $3 and lower(e.grantee_email)=lower($2))",
+		user.ID, creds.Email, creds.EmailVerified,
+	)
+	if err != nil {
+		log.Printf("[ListEmergencyAccess] db error (granted): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, emergencyAccessListResponse{Trusted: trusted, Granted: granted})
+}
+
+// InviteEmergencyContact приглашает доверенный контакт по email. Аккаунт у него может
+// появиться и позже: приглашение привязывается к адресу.
+func (h *AuthHandler) InviteEmergencyContact(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req emergencyInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if !strings.Contains(email, "@") || strings.EqualFold(email, user.Email) {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+	if req.WaitDays == 0 {
+		req.WaitDays = defaultEmergencyWaitDays
+	}
+	if req.WaitDays < 1 || req.WaitDays > maxEmergencyWaitDays {
+		http.Error(w, "invalid wait period", http.StatusBadRequest)
+		return
+	}
+
+	mailKey := mailThrottleKey(user.ID)
+	if h.throttled(w, r, mailKey) {
+		return
+	}
+
+	ctx := r.Context()
+	var count int
+	if err := h.DB.QueryRow(ctx, "select count(*) from emergency_access where grantor_id=$1", user.ID).Scan(&count); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxEmergencyContacts {
+		http.Error(w, "too many emergency contacts", http.StatusConflict)
+		return
+	}
+
+	var id string
+	err := h.DB.QueryRow(ctx,
+		"insert into emergency_access (grantor_id, grantee_email, wait_days) values ($1, $2, $3) returning id",
+		user.ID, email, req.WaitDays,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			http.Error(w, "already invited", http.StatusConflict)
+			return
+		}
+		log.Printf("[InviteEmergencyContact] db error (insert): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordFailure(w, r, mailKey)
+	h.sendMailInBackground(email, mail.TemplateEmergencyAccessInvite, struct {
+		GrantorEmail string
+		WaitDays     int
+	}{user.Email, req.WaitDays})
+
+	items, err := h.queryEmergencyAccess(ctx, true, "e.id=$1", id)
+	if err != nil || len(items) == 0 {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, items[0])
+}
+
+// AcceptEmergencyInvite — приглашённый принимает приглашение, отправленное на его
+// подтверждённый адрес.
+func (h *AuthHandler) AcceptEmergencyInvite(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	creds, err := h.findUserByID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !creds.EmailVerified {
+		http.Error(w, "email not verified", http.StatusForbidden)
+		return
+	}
+
+	commandTag, err := h.DB.Exec(ctx, `
+		update emergency_access set grantee_id=$1, status=$2, updated_at=now()
+		where id::text=$3 and status=$4 and grantee_id is null and lower(grantee_email)=lower($5) and grantor_id<>$1`,
+		user.ID, emergencyStatusAccepted, id, emergencyStatusInvited, creds.Email,
+	)
+	if err != nil {
+		log.Printf("[AcceptEmergencyInvite] db error (update): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ConfirmEmergencyContact — grantor передаёт ключ хранилища, зашифрованный открытым ключом
+// grantee. Ключ шифрует клиент: сервер расшифровать его не может.
+func (h *AuthHandler) ConfirmEmergencyContact(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	var req emergencyConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	keyEncrypted, err := base64.StdEncoding.DecodeString(req.KeyEncrypted)
+	if err != nil || len(keyEncrypted) == 0 {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if !h.requireReauth(w, r, user.ID, req.reauthRequest) {
+		return
+	}
+
+	h.transitionEmergencyAccess(w, r, id, "grantor_id=$1", user.ID,
+		[]string{emergencyStatusAccepted}, "status='confirmed', key_encrypted=$4", keyEncrypted)
+}
+
+// InitiateEmergencyAccess — grantee запрашивает доступ. Grantor получает письмо и может
+// отклонить запрос до конца периода ожидания.
+func (h *AuthHandler) InitiateEmergencyAccess(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	if !h.transitionEmergencyAccess(w, r, id, "grantee_id=$1", user.ID,
+		[]string{emergencyStatusConfirmed}, "status='recovery_initiated', recovery_initiated_at=now()") {
+		return
+	}
+
+	ctx := r.Context()
+	grant, err := h.findEmergencyAccess(ctx, id)
+	if err != nil {
+		log.Printf("[InitiateEmergencyAccess] db error (reload): %v", err)
+		return
+	}
+	h.logSecurityEvent(ctx, r, grant.GrantorID, eventEmergencyAccessRequested, "grantee="+user.Email)
+	if grantor, err := h.findUserByID(ctx, grant.GrantorID); err == nil {
+		h.notify(grantor, mail.TemplateEmergencyAccessRequested, struct {
+			GranteeEmail string
+			WaitDays     int
+		}{user.Email, grant.WaitDays})
+	}
+}
+
+// ApproveEmergencyAccess — grantor открывает доступ, не дожидаясь конца периода ожидания.
+func (h *AuthHandler) ApproveEmergencyAccess(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	h.transitionEmergencyAccess(w, r, id, "grantor_id=$1", user.ID,
+		[]string{emergencyStatusRecoveryInitiated}, "status='recovery_approved'")
+}
+
+// RejectEmergencyAccess — grantor отклоняет запрос (в том числе уже одобренный по таймеру);
+// доверенность остаётся, и grantee может запросить доступ снова.
+func (h *AuthHandler) RejectEmergencyAccess(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	h.transitionEmergencyAccess(w, r, id, "grantor_id=$1", user.ID,
+		[]string{emergencyStatusRecoveryInitiated, emergencyStatusRecoveryApproved},
+		"status='confirmed', recovery_initiated_at=null")
+}
+
+// EmergencyVault отдаёт grantee зашифрованный ключ и шифротексты хранилища grantor
+// после одобрения запроса.
+func (h *AuthHandler) EmergencyVault(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	grant, err := h.findEmergencyAccess(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && (grant.GranteeID == nil || *grant.GranteeID != user.ID)) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[EmergencyVault] db error (find): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if grant.Status != emergencyStatusRecoveryApproved {
+		http.Error(w, "access not approved", http.StatusForbidden)
+		return
+	}
+
+	items, err := listItems(ctx, h.DB, grant.GrantorID, itemFilter{})
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	folders, err := listFolders(ctx, h.DB, grant.GrantorID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	accounts := make([]accountResponse, 0)
+	notes := make([]noteResponse, 0)
+	for _, item := range items {
+		switch item.Type {
+		case itemTypeLogin:
+			accounts = append(accounts, accountFromItem(item))
+		case itemTypeNote:
+			notes = append(notes, noteFromItem(item))
+		}
+	}
+	h.logSecurityEvent(ctx, r, grant.GrantorID, eventEmergencyVaultAccessed, "grantee="+user.Email)
+
+	respondJSON(w, emergencyVaultResponse{
+		KeyEncrypted: base64.StdEncoding.EncodeToString(grant.KeyEncrypted),
+		Accounts:     accounts,
+		Notes:        notes,
+		Items:        items,
+		Folders:      folders,
+	})
+}
+
+// DeleteEmergencyAccess удаляет доверенность; это может сделать любая из сторон,
+// а приглашённый — и до принятия приглашения.
+func (h *AuthHandler) DeleteEmergencyAccess(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	creds, err := h.findUserByID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	// Приглашение по адресу может отклонить только владелец подтверждённого адреса, как и в Accept.
+	commandTag, err := h.DB.Exec(ctx, `
+		delete from emergency_access
+		where id::text=$1 and (grantor_id=$2 or grantee_id=$2 or (grantee_id is null and $4 and lower(grantee_email)=lower($3)))`,
+		id, user.ID, creds.Email, creds.EmailVerified,
+	)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transitionEmergencyAccess переводит доверенность из одного из статусов from, применяя set.
+// party — условие на сторону ($1 — id пользователя). Аргументы set начинаются с $4.
+// Сам пишет ответ: 204 при успехе, 404 или 409 иначе.
+func (h *AuthHandler) transitionEmergencyAccess(w http.ResponseWriter, r *http.Request, id, party, userID string, from []string, set string, args ...any) bool {
+	ctx := r.Context()
+	params := append([]any{userID, id, from}, args...)
+	commandTag, err := h.DB.Exec(ctx, `
+		update emergency_access e set `+set+`, updated_at=now()
+		where `+party+` and e.id::text=$2 and (`+emergencyStatusColumn+`)=any($3)`,
+		params...,
+	)
+	if err != nil {
+		log.Printf("[emergency access] db error (transition): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	if commandTag.RowsAffected() > 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow(ctx,
+		"select exists(select 1 from emergency_access where "+party+" and id::text=$2)", userID, id,
+	).Scan(&exists); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		http.Error(w, "not found", http.StatusNotFound)
+		return false
+	}
+	http.Error(w, "invalid state", http.StatusConflict)
+	return false
+}
+
+func (h *AuthHandler) findEmergencyAccess(ctx context.Context, id string) (emergencyAccess, error) {
+	var grant emergencyAccess
+	err := h.DB.QueryRow(ctx, `
+		select e.id, e.grantor_id, e.grantee_id, e.grantee_email, `+emergencyStatusColumn+`, e.wait_days, e.key_encrypted
+		from emergency_access e where e.id::text=$1`, id,
+	).Scan(&grant.ID, &grant.GrantorID, &grant.GranteeID, &grant.GranteeEmail, &grant.Status, &grant.WaitDays, &grant.KeyEncrypted)
+	return grant, err
+}
+
+// queryEmergencyAccess читает доверенности по условию where; withPublicKey — для стороны grantor.
+func (h *AuthHandler) queryEmergencyAccess(ctx context.Context, withPublicKey bool, where string, args ...any) ([]emergencyAccessResponse, error) {
+	rows, err := h.DB.Query(ctx, `
+		select e.id, grantor.email, e.grantee_email, `+emergencyStatusColumn+`, e.wait_days, e.recovery_initiated_at,
+			grantee.public_key, e.created_at
+		from emergency_access e
+		join users grantor on grantor.id=e.grantor_id
+		left join users grantee on grantee.id=e.grantee_id
+		where `+where+`
+		order by e.created_at desc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]emergencyAccessResponse, 0)
+	for rows.Next() {
+		var item emergencyAccessResponse
+		var publicKey []byte
+		if err := rows.Scan(
+			&item.ID,
+			&item.GrantorEmail,
+			&item.GranteeEmail,
+			&item.Status,
+			&item.WaitDays,
+			&item.RecoveryInitiatedAt,
+			&publicKey,
+			&item.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if withPublicKey && publicKey != nil {
+			item.GranteePublicKey = base64.StdEncoding.EncodeToString(publicKey)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
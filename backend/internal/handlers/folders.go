@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"passkeys/internal/middleware"
+)
+
+// FolderHandler управляет папками. Имя папки зашифровано ключом хранилища, как и записи;
+// вложенность задаётся parentId. Удалённая папка отдаёт свои записи и вложенные папки в корень.
+type FolderHandler struct {
+	DB *pgxpool.Pool
+}
+
+// folderRoot в фильтре folder списков записей означает записи вне папок.
+const folderRoot = "root"
+
+var (
+	errUnknownFolder = errors.New("unknown folder")
+	errFolderCycle   = errors.New("folder cannot be moved into itself")
+)
+
+type folderRequest struct {
+	// ParentID — родительская папка; пусто — папка в корне.
+	ParentID   *string `json:"parentId"`
+	NameCipher string  `json:"nameCipher"`
+	NameNonce  string  `json:"nameNonce"`
+}
+
+type folderResponse struct {
+	ID         string    `json:"id"`
+	ParentID   *string   `json:"parentId"`
+	NameCipher string    `json:"nameCipher"`
+	NameNonce  string    `json:"nameNonce"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+const folderColumns = "id, parent_id, name_cipher, name_nonce, created_at, updated_at"
+
+func (h *FolderHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	folders, err := listFolders(r.Context(), h.DB, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, folders)
+}
+
+func (h *FolderHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req folderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	nameCipher, nameNonce, err := decodeFolderName(req)
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	parentID := emptyToNil(req.ParentID)
+	if err := checkFolder(ctx, h.DB, user.ID, parentID); err != nil {
+		folderError(w, "create", err)
+		return
+	}
+
+	folder, err := scanFolder(h.DB.QueryRow(ctx, `
+		insert into folders (user_id, parent_id, name_cipher, name_nonce) values ($1, $2, $3, $4)
+		returning `+folderColumns,
+		user.ID, parentID, nameCipher, nameNonce,
+	))
+	if err != nil {
+		log.Printf("[Folders] db error (create): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, folder)
+}
+
+// Update переименовывает и перемещает папку. Переместить папку в неё саму или во вложенную
+// нельзя: проверка и запись идут под блокировкой папок пользователя.
+func (h *FolderHandler) Update(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	folderID := chi.URLParam(r, "id")
+	if folderID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	var req folderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	nameCipher, nameNonce, err := decodeFolderName(req)
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	parentID := emptyToNil(req.ParentID)
+
+	ctx := r.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "select 1 from folders where user_id=$1 for update", user.ID); err != nil {
+		log.Printf("[Folders] db error (lock): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := checkFolder(ctx, tx, user.ID, parentID); err != nil {
+		folderError(w, "update", err)
+		return
+	}
+	if parentID != nil {
+		var cycle bool
+		err := tx.QueryRow(ctx, `
+			with recursive ancestors(id, parent_id) as (
+				select id, parent_id from folders where id::text=$1 and user_id=$2
+				union
+				select f.id, f.parent_id from folders f join ancestors a on f.id=a.parent_id
+			)
+			select exists(select 1 from ancestors where id::text=$3)`,
+			*parentID, user.ID, folderID,
+		).Scan(&cycle)
+		if err != nil {
+			log.Printf("[Folders] db error (ancestors): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if cycle {
+			folderError(w, "update", errFolderCycle)
+			return
+		}
+	}
+
+	folder, err := scanFolder(tx.QueryRow(ctx, `
+		update folders set parent_id=$1, name_cipher=$2, name_nonce=$3, updated_at=now()
+		where id=$4 and user_id=$5
+		returning `+folderColumns,
+		parentID, nameCipher, nameNonce, folderID, user.ID,
+	))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, folder)
+}
+
+// Delete удаляет папку; её записи и вложенные папки переходят в корень (on delete set null).
+func (h *FolderHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	folderID := chi.URLParam(r, "id")
+	if folderID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	commandTag, err := h.DB.Exec(r.Context(), "delete from folders where id=$1 and user_id=$2", folderID, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeFolderName(req folderRequest) ([]byte, []byte, error) {
+	nameCipher, err := base64.StdEncoding.DecodeString(req.NameCipher)
+	if err != nil {
+		return nil, nil, err
+	}
+	nameNonce, err := base64.StdEncoding.DecodeString(req.NameNonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nameCipher) == 0 || len(nameNonce) == 0 {
+		return nil, nil, errors.New("folder name required")
+	}
+	return nameCipher, nameNonce, nil
+}
+
+// folderError отвечает на ошибку проверки папки; остальные ошибки — ошибки БД.
+func folderError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, errUnknownFolder), errors.Is(err, errFolderCycle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("[Folders] db error (%s): %v", action, err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+	}
+}
+
+// emptyToNil переводит пустую ссылку на папку в nil — корень.
+func emptyToNil(folderID *string) *string {
+	if folderID == nil || *folderID == "" {
+		return nil
+	}
+	return folderID
+}
+
+// queryRower — пул или транзакция.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// checkFolder проверяет, что папка folderID (nil — корень) принадлежит пользователю.
+func checkFolder(ctx context.Context, db queryRower, userID string, folderID *string) error {
+	if folderID == nil {
+		return nil
+	}
+	var exists bool
+	if err := db.QueryRow(ctx,
+		"select exists(select 1 from folders where id::text=$1 and user_id=$2)", *folderID, userID,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errUnknownFolder
+	}
+	return nil
+}
+
+func scanFolder(row pgx.Row) (folderResponse, error) {
+	var folder folderResponse
+	var nameCipher, nameNonce []byte
+	err := row.Scan(&folder.ID, &folder.ParentID, &nameCipher, &nameNonce, &folder.CreatedAt, &folder.UpdatedAt)
+	folder.NameCipher = base64.StdEncoding.EncodeToString(nameCipher)
+	folder.NameNonce = base64.StdEncoding.EncodeToString(nameNonce)
+	return folder, err
+}
+
+func listFolders(ctx context.Context, db *pgxpool.Pool, userID string) ([]folderResponse, error) {
+	rows, err := db.Query(ctx, "select "+folderColumns+" from folders where user_id=$1 order by created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := make([]folderResponse, 0)
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// withFolderRoutes добавляет к маршрутизатору env папки и записи, как в cmd/api.
+func withFolderRoutes(env *vaultTestEnv) *vaultTestEnv {
+	folders := &FolderHandler{DB: env.pool}
+	items := &ItemHandler{DB: env.pool}
+	accounts := &AccountHandler{DB: env.pool}
+	notes := &NoteHandler{DB: env.pool}
+	env.router.Post("/folders", folders.Create)
+	env.router.Put("/folders/{id}", folders.Update)
+	env.router.Delete("/folders/{id}", folders.Delete)
+	env.router.Post("/items", items.Create)
+	env.router.Put("/items/{id}", items.Update)
+	env.router.Post("/accounts", accounts.Create)
+	env.router.Put("/accounts/{id}", accounts.Update)
+	env.router.Post("/notes", notes.Create)
+	env.router.Put("/notes/{id}", notes.Update)
+	return env
+}
+
+func (e *vaultTestEnv) folderParent(id string) *string {
+	e.t.Helper()
+	var parentID *string
+	if err := e.pool.QueryRow(context.Background(), "select parent_id::text from folders where id=$1", id).Scan(&parentID); err != nil {
+		e.t.Fatal(err)
+	}
+	return parentID
+}
+
+func (e *vaultTestEnv) itemFolder(id string) *string {
+	e.t.Helper()
+	var folderID *string
+	if err := e.pool.QueryRow(context.Background(), "select folder_id::text from items where id=$1", id).Scan(&folderID); err != nil {
+		e.t.Fatal(err)
+	}
+	return folderID
+}
+
+func folderBody(parentID string) folderRequest {
+	return folderRequest{ParentID: &parentID, NameCipher: "bmFtZQ==", NameNonce: "bm9uY2U="}
+}
+
+func sameFolder(a, b *string) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func TestFolderUpdateRejectsCycle(t *testing.T) {
+	env := withFolderRoutes(newVaultTestEnv(t))
+	user := env.createUser("folders@example.com")
+	// top → middle → bottom
+	top := env.insertFolder(user.id, nil)
+	middle := env.insertFolder(user.id, &top)
+	bottom := env.insertFolder(user.id, &middle)
+
+	tests := []struct {
+		name     string
+		folder   string
+		parentID string
+		want     int
+	}{
+		{"into itself", top, top, http.StatusBadRequest},
+		{"into child", top, middle, http.StatusBadRequest},
+		{"into grandchild", top, bottom, http.StatusBadRequest},
+		{"middle into its child", middle, bottom, http.StatusBadRequest},
+		{"leaf into itself", bottom, bottom, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(parent *testing.T) { env.t = parent }(env.t)
+			env.t = t
+			before := env.folderParent(tt.folder)
+			if rec := env.do(user, http.MethodPut, "/folders/"+tt.folder, folderBody(tt.parentID)); rec.Code != tt.want {
+				t.Fatalf("move: %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if after := env.folderParent(tt.folder); !sameFolder(before, after) {
+				t.Errorf("parent changed from %v to %v", before, after)
+			}
+		})
+	}
+
+	// Перемещения без цикла проходят: вверх по дереву, в соседнюю ветку и в корень.
+	if rec := env.do(user, http.MethodPut, "/folders/"+bottom, folderBody(top)); rec.Code != http.StatusOK {
+		t.Fatalf("move bottom to top: %d %s", rec.Code, rec.Body)
+	}
+	if rec := env.do(user, http.MethodPut, "/folders/"+middle, folderBody(bottom)); rec.Code != http.StatusOK {
+		t.Fatalf("move middle under its former child: %d %s", rec.Code, rec.Body)
+	}
+	if parent := env.folderParent(middle); parent == nil || *parent != bottom {
+		t.Errorf("middle parent = %v, want %s", parent, bottom)
+	}
+	if rec := env.do(user, http.MethodPut, "/folders/"+top, folderBody("")); rec.Code != http.StatusOK {
+		t.Fatalf("move top to root: %d %s", rec.Code, rec.Body)
+	}
+	if parent := env.folderParent(top); parent != nil {
+		t.Errorf("top parent = %v, want root", *parent)
+	}
+}
+
+func TestFoldersRejectAnotherUsersFolder(t *testing.T) {
+	env := withFolderRoutes(newVaultTestEnv(t))
+	user := env.createUser("folders@example.com")
+	other := env.createUser("other@example.com")
+	foreign := env.insertFolder(other.id, nil)
+	own := env.insertFolder(user.id, nil)
+	login := env.insertItem(user.id, itemTypeLogin, false, "username", "password")
+	note := env.insertItem(user.id, itemTypeNote, false, "title", "text")
+	field := testField("value")
+
+	for _, folderID := range []string{foreign, "00000000-0000-0000-0000-000000000000", "not-a-uuid"} {
+		tests := []struct {
+			name   string
+			method string
+			path   string
+			body   any
+		}{
+			{"create folder", http.MethodPost, "/folders", folderBody(folderID)},
+			{"move folder", http.MethodPut, "/folders/" + own, folderBody(folderID)},
+			{"create item", http.MethodPost, "/items", itemRequest{Type: itemTypeCard, FolderID: &folderID, Payload: itemPayload{"number": field}}},
+			{"move item", http.MethodPut, "/items/" + login, itemRequest{FolderID: &folderID, Payload: itemPayload{"username": field, "password": field}}},
+			{"create account", http.MethodPost, "/accounts", accountRequest{FolderID: &folderID, URL: "https://example.com",
+				UsernameCipher: field.Cipher, UsernameNonce: field.Nonce, PasswordCipher: field.Cipher, PasswordNonce: field.Nonce}},
+			{"move account", http.MethodPut, "/accounts/" + login, accountRequest{FolderID: &folderID, URL: "https://example.com",
+				UsernameCipher: field.Cipher, UsernameNonce: field.Nonce, PasswordCipher: field.Cipher, PasswordNonce: field.Nonce}},
+			{"create note", http.MethodPost, "/notes", noteRequest{FolderID: &folderID,
+				TitleCipher: field.Cipher, TitleNonce: field.Nonce, TextCipher: field.Cipher, TextNonce: field.Nonce}},
+			{"move note", http.MethodPut, "/notes/" + note, noteRequest{FolderID: &folderID,
+				TitleCipher: field.Cipher, TitleNonce: field.Nonce, TextCipher: field.Cipher, TextNonce: field.Nonce}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name+" "+folderID, func(t *testing.T) {
+				defer func(parent *testing.T) { env.t = parent }(env.t)
+				env.t = t
+				if rec := env.do(user, tt.method, tt.path, tt.body); rec.Code != http.StatusBadRequest {
+					t.Fatalf("%s %s: %d %s, want 400", tt.method, tt.path, rec.Code, rec.Body)
+				}
+			})
+		}
+	}
+
+	ctx := context.Background()
+	var folders, items int
+	if err := env.pool.QueryRow(ctx, "select count(*) from folders where user_id=$1", user.id).Scan(&folders); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.pool.QueryRow(ctx, "select count(*) from items where user_id=$1", user.id).Scan(&items); err != nil {
+		t.Fatal(err)
+	}
+	if folders != 1 || items != 2 {
+		t.Errorf("user has %d folders and %d items, want 1 and 2", folders, items)
+	}
+	if parent := env.folderParent(own); parent != nil {
+		t.Errorf("own folder moved into %s", *parent)
+	}
+	for _, id := range []string{login, note} {
+		if folder := env.itemFolder(id); folder != nil {
+			t.Errorf("item %s moved into %s", id, *folder)
+		}
+	}
+
+	// Чужую папку нельзя ни переименовать, ни удалить.
+	if rec := env.do(user, http.MethodPut, "/folders/"+foreign, folderBody("")); rec.Code != http.StatusNotFound {
+		t.Errorf("rename another user's folder: %d %s, want 404", rec.Code, rec.Body)
+	}
+	if rec := env.do(user, http.MethodDelete, "/folders/"+foreign, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("delete another user's folder: %d %s, want 404", rec.Code, rec.Body)
+	}
+	var exists bool
+	if err := env.pool.QueryRow(ctx, "select exists(select 1 from folders where id=$1 and name_cipher='old name')", foreign).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("another user's folder was changed")
+	}
+
+	// Своя папка принимается теми же запросами.
+	if rec := env.do(user, http.MethodPut, "/items/"+login, itemRequest{FolderID: &own, Payload: itemPayload{"username": field, "password": field}}); rec.Code != http.StatusOK {
+		t.Fatalf("move item into own folder: %d %s", rec.Code, rec.Body)
+	}
+	if folder := env.itemFolder(login); folder == nil || *folder != own {
+		t.Errorf("item folder = %v, want %s", folder, own)
+	}
+}
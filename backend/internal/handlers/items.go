@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"passkeys/internal/middleware"
+)
+
+type ItemHandler struct {
+	DB *pgxpool.Pool
+	// TrashRetention — сколько удалённые записи лежат в корзине до окончательного удаления.
+	TrashRetention time.Duration
+}
+
+// Типы записей хранилища. Содержимое сервер не видит: тип нужен клиентам, чтобы выбрать
+// форму записи, и представлениям /accounts и /notes.
+const (
+	itemTypeLogin    = "login"
+	itemTypeNote     = "note"
+	itemTypeCard     = "card"
+	itemTypeIdentity = "identity"
+	itemTypeSSHKey   = "ssh_key"
+)
+
+var itemTypes = []string{itemTypeLogin, itemTypeNote, itemTypeCard, itemTypeIdentity, itemTypeSSHKey}
+
+// Ограничения на размер записи: метаданные открыты и не должны превращаться в хранилище.
+const (
+	maxItemMetadataSize = 4 << 10
+	maxItemFields       = 64
+)
+
+// encryptedField — значение, зашифрованное ключом хранилища (AES-GCM), в base64.
+type encryptedField struct {
+	Cipher string `json:"cipher"`
+	Nonce  string `json:"nonce"`
+}
+
+// itemPayload — конверт зашифрованных полей записи: имена полей открыты, значения — нет.
+type itemPayload map[string]encryptedField
+
+type itemRequest struct {
+	// Type задаётся при создании и потом не меняется.
+	Type string `json:"type"`
+	// FolderID — папка записи: пустая строка — корень; без поля при изменении папка не меняется.
+	FolderID *string `json:"folderId,omitempty"`
+	// Metadata хранится открыто (адрес сайта, подпись) — секретов в ней быть не должно.
+	Metadata map[string]string `json:"metadata"`
+	Payload  itemPayload       `json:"payload"`
+}
+
+type itemResponse struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	FolderID  *string           `json:"folderId"`
+	Metadata  map[string]string `json:"metadata"`
+	Payload   itemPayload       `json:"payload"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	// DeletedAt задан у записей в корзине.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// itemFilter отбирает записи для списка; пустые поля не ограничивают.
+type itemFilter struct {
+	Type string
+	// Folder — id папки (без вложенных) или folderRoot для записей вне папок.
+	Folder string
+	// Trash — записи из корзины вместо обычных.
+	Trash bool
+}
+
+const itemColumns = "id, type, folder_id, metadata, payload, created_at, updated_at, deleted_at"
+
+func (h *ItemHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemType := r.URL.Query().Get("type")
+	if itemType != "" && !validItemType(itemType) {
+		http.Error(w, "unknown item type", http.StatusBadRequest)
+		return
+	}
+
+	items, err := listItems(r.Context(), h.DB, user.ID, itemFilter{Type: itemType, Folder: r.URL.Query().Get("folder")})
+	if err != nil {
+		log.Printf("[Items] db error (list): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, items)
+}
+
+func (h *ItemHandler) Get(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID := chi.URLParam(r, "id")
+	if itemID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	item, err := scanItem(h.DB.QueryRow(r.Context(),
+		"select "+itemColumns+" from items where id=$1 and user_id=$2", itemID, user.ID))
+	if err != nil {
+		itemError(w, "get", err)
+		return
+	}
+
+	respondJSON(w, item)
+}
+
+func (h *ItemHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req itemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !validItemType(req.Type) {
+		http.Error(w, "unknown item type", http.StatusBadRequest)
+		return
+	}
+	if len(req.Payload) == 0 {
+		http.Error(w, "missing fields", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	item, err := createItem(r.Context(), h.DB, user.ID, req)
+	if err != nil {
+		itemError(w, "create", err)
+		return
+	}
+
+	respondJSON(w, item)
+}
+
+// Update заменяет метаданные и конверт записи целиком. Тип не меняется: если он указан
+// и не совпадает, запись не найдена.
+func (h *ItemHandler) Update(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID := chi.URLParam(r, "id")
+	if itemID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	var req itemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Payload) == 0 {
+		http.Error(w, "missing fields", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	item, err := replaceItem(r.Context(), h.DB, user.ID, itemID, req)
+	if err != nil {
+		itemError(w, "update", err)
+		return
+	}
+
+	respondJSON(w, item)
+}
+
+// Delete переносит запись в корзину; удалить окончательно можно через /trash.
+func (h *ItemHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID := chi.URLParam(r, "id")
+	if itemID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := trashItem(r.Context(), h.DB, user.ID, itemID, "")
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// itemError отвечает на ошибку чтения или записи записи.
+func itemError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, errUnknownFolder):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("[Items] db error (%s): %v", action, err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+	}
+}
+
+func validItemType(itemType string) bool {
+	for _, t := range itemTypes {
+		if t == itemType {
+			return true
+		}
+	}
+	return false
+}
+
+// validate проверяет, что зашифрованные поля — base64, а метаданные не слишком велики.
+func (req itemRequest) validate() error {
+	if len(req.Payload) > maxItemFields {
+		return errors.New("too many fields")
+	}
+	for name, field := range req.Payload {
+		if name == "" {
+			return errors.New("empty field name")
+		}
+		if _, err := base64.StdEncoding.DecodeString(field.Cipher); err != nil {
+			return err
+		}
+		if _, err := base64.StdEncoding.DecodeString(field.Nonce); err != nil {
+			return err
+		}
+	}
+	metadata, err := json.Marshal(req.metadata())
+	if err != nil {
+		return err
+	}
+	if len(metadata) > maxItemMetadataSize {
+		return errors.New("metadata too large")
+	}
+	return nil
+}
+
+// metadata — метаданные запроса; отсутствующие сохраняются пустым объектом, а не null.
+func (req itemRequest) metadata() map[string]string {
+	if req.Metadata == nil {
+		return map[string]string{}
+	}
+	return req.Metadata
+}
+
+func scanItem(row pgx.Row) (itemResponse, error) {
+	var item itemResponse
+	err := row.Scan(&item.ID, &item.Type, &item.FolderID, &item.Metadata, &item.Payload, &item.CreatedAt, &item.UpdatedAt, &item.DeletedAt)
+	return item, err
+}
+
+// listItems возвращает записи пользователя по filter, новые изменения первыми.
+// Записи из корзины попадают в список только с filter.Trash.
+func listItems(ctx context.Context, db *pgxpool.Pool, userID string, filter itemFilter) ([]itemResponse, error) {
+	rows, err := db.Query(ctx, `
+		select `+itemColumns+` from items
+		where user_id=$1 and ($2='' or type=$2)
+			and ($3='' or ($3=$4 and folder_id is null) or folder_id::text=$3)
+			and (deleted_at is not null)=$5
+		order by updated_at desc`,
+		userID, filter.Type, filter.Folder, folderRoot, filter.Trash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]itemResponse, 0)
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// createItem создаёт запись; errUnknownFolder — папка не найдена у пользователя.
+func createItem(ctx context.Context, db *pgxpool.Pool, userID string, req itemRequest) (itemResponse, error) {
+	folderID := emptyToNil(req.FolderID)
+	if err := checkFolder(ctx, db, userID, folderID); err != nil {
+		return itemResponse{}, err
+	}
+	return scanItem(db.QueryRow(ctx, `
+		insert into items (user_id, type, folder_id, metadata, payload) values ($1, $2, $3, $4, $5)
+		returning `+itemColumns,
+		userID, req.Type, folderID, req.metadata(), req.Payload,
+	))
+}
+
+// replaceItem заменяет метаданные и конверт записи; тип, если задан, должен совпасть.
+// Записи в корзине не меняются — их сначала восстанавливают.
+func replaceItem(ctx context.Context, db *pgxpool.Pool, userID, itemID string, req itemRequest) (itemResponse, error) {
+	folderID := emptyToNil(req.FolderID)
+	if err := checkFolder(ctx, db, userID, folderID); err != nil {
+		return itemResponse{}, err
+	}
+	return scanItem(db.QueryRow(ctx, `
+		update items
+		set metadata=$1, payload=$2, folder_id=case when $3 then $4::uuid else folder_id end, updated_at=now()
+		where id=$5 and user_id=$6 and ($7='' or type=$7) and deleted_at is null
+		returning `+itemColumns,
+		req.metadata(), req.Payload, req.FolderID != nil, folderID, itemID, userID, req.Type,
+	))
+}
+
+// mergeItem дописывает поля представления (/accounts, /notes) в запись типа req.Type:
+// поля и метаданные, которых представление не знает, остаются как были.
+func mergeItem(ctx context.Context, db *pgxpool.Pool, userID, itemID string, req itemRequest) (itemResponse, error) {
+	folderID := emptyToNil(req.FolderID)
+	if err := checkFolder(ctx, db, userID, folderID); err != nil {
+		return itemResponse{}, err
+	}
+	return scanItem(db.QueryRow(ctx, `
+		update items
+		set metadata=metadata || $1, payload=payload || $2,
+			folder_id=case when $3 then $4::uuid else folder_id end, updated_at=now()
+		where id=$5 and user_id=$6 and type=$7 and deleted_at is null
+		returning `+itemColumns,
+		req.metadata(), req.Payload, req.FolderID != nil, folderID, itemID, userID, req.Type,
+	))
+}
+
+// trashItem переносит запись в корзину; пустой itemType — запись любого типа.
+func trashItem(ctx context.Context, db *pgxpool.Pool, userID, itemID, itemType string) (bool, error) {
+	commandTag, err := db.Exec(ctx,
+		"update items set deleted_at=now() where id=$1 and user_id=$2 and ($3='' or type=$3) and deleted_at is null",
+		itemID, userID, itemType)
+	if err != nil {
+		return false, err
+	}
+	return commandTag.RowsAffected() > 0, nil
+}
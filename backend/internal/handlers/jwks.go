@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// JWKS публикует открытые ключи подписи, чтобы другие сервисы проверяли токены
+// без общего секрета. Кэш короче задержки публикации нового ключа.
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, h.Keys.JWKS())
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import "fmt"
+
+const (
+	kdfPBKDF2SHA256 = "pbkdf2-sha256"
+	kdfArgon2id     = "argon2id"
+)
+
+// Границы допустимых параметров: нижние не дают ослабить KDF, верхние — повесить клиента.
+const (
+	minPBKDF2Iterations  = 100000
+	maxPBKDF2Iterations  = 10000000
+	minArgon2Iterations  = 2
+	maxArgon2Iterations  = 10
+	minArgon2MemoryKiB   = 16 * 1024
+	maxArgon2MemoryKiB   = 1024 * 1024
+	maxArgon2Parallelism = 16
+	defaultKdfIterations = minPBKDF2Iterations
+	defaultKdfAlgorithm  = kdfPBKDF2SHA256
+)
+
+// kdfParams описывает, как клиент выводит ключ хранилища из мастер-пароля.
+// Сервер сам KDF не выполняет, только хранит и отдаёт параметры.
+type kdfParams struct {
+	Algorithm   string `json:"kdfAlgorithm"`
+	Iterations  int    `json:"kdfIterations"`
+	Memory      *int   `json:"kdfMemory,omitempty"`
+	Parallelism *int   `json:"kdfParallelism,omitempty"`
+}
+
+func defaultKdfParams() kdfParams {
+	return kdfParams{Algorithm: defaultKdfAlgorithm, Iterations: defaultKdfIterations}
+}
+
+// normalize подставляет значения по умолчанию для старых клиентов, которые параметры не присылают,
+// и проверяет, что параметры не слабее допустимых.
+func (p kdfParams) normalize() (kdfParams, error) {
+	if p.Algorithm == "" && p.Iterations == 0 && p.Memory == nil && p.Parallelism == nil {
+		return defaultKdfParams(), nil
+	}
+
+	switch p.Algorithm {
+	case kdfPBKDF2SHA256:
+		if p.Iterations < minPBKDF2Iterations || p.Iterations > maxPBKDF2Iterations {
+			return p, fmt.Errorf("pbkdf2 iterations must be %d-%d", minPBKDF2Iterations, maxPBKDF2Iterations)
+		}
+		if p.Memory != nil || p.Parallelism != nil {
+			return p, fmt.Errorf("pbkdf2 takes no memory or parallelism")
+		}
+	case kdfArgon2id:
+		if p.Iterations < minArgon2Iterations || p.Iterations > maxArgon2Iterations {
+			return p, fmt.Errorf("argon2id iterations must be %d-%d", minArgon2Iterations, maxArgon2Iterations)
+		}
+		if p.Memory == nil || *p.Memory < minArgon2MemoryKiB || *p.Memory > maxArgon2MemoryKiB {
+			return p, fmt.Errorf("argon2id memory must be %d-%d KiB", minArgon2MemoryKiB, maxArgon2MemoryKiB)
+		}
+		if p.Parallelism == nil || *p.Parallelism < 1 || *p.Parallelism > maxArgon2Parallelism {
+			return p, fmt.Errorf("argon2id parallelism must be 1-%d", maxArgon2Parallelism)
+		}
+	default:
+		return p, fmt.Errorf("unsupported kdf algorithm %q", p.Algorithm)
+	}
+	return p, nil
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import "testing"
+
+func intPtr(v int) *int { return &v }
+
+func TestKdfParamsNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  kdfParams
+		want    kdfParams
+		wantErr bool
+	}{
+		{name: "empty means default", params: kdfParams{}, want: defaultKdfParams()},
+		{
+			name:   "pbkdf2 min",
+			params: kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: minPBKDF2Iterations},
+			want:   kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: minPBKDF2Iterations},
+		},
+		{
+			name:   "pbkdf2 max",
+			params: kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: maxPBKDF2Iterations},
+			want:   kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: maxPBKDF2Iterations},
+		},
+		{name: "pbkdf2 too few iterations", params: kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: minPBKDF2Iterations - 1}, wantErr: true},
+		{name: "pbkdf2 too many iterations", params: kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: maxPBKDF2Iterations + 1}, wantErr: true},
+		{name: "pbkdf2 with memory", params: kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: minPBKDF2Iterations, Memory: intPtr(minArgon2MemoryKiB)}, wantErr: true},
+		{name: "pbkdf2 with parallelism", params: kdfParams{Algorithm: kdfPBKDF2SHA256, Iterations: minPBKDF2Iterations, Parallelism: intPtr(1)}, wantErr: true},
+		{name: "iterations without algorithm", params: kdfParams{Iterations: minPBKDF2Iterations}, wantErr: true},
+		{name: "unknown algorithm", params: kdfParams{Algorithm: "scrypt", Iterations: 3}, wantErr: true},
+		{
+			name:   "argon2id min",
+			params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Memory: intPtr(minArgon2MemoryKiB), Parallelism: intPtr(1)},
+			want:   kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Memory: intPtr(minArgon2MemoryKiB), Parallelism: intPtr(1)},
+		},
+		{
+			name:   "argon2id max",
+			params: kdfParams{Algorithm: kdfArgon2id, Iterations: maxArgon2Iterations, Memory: intPtr(maxArgon2MemoryKiB), Parallelism: intPtr(maxArgon2Parallelism)},
+			want:   kdfParams{Algorithm: kdfArgon2id, Iterations: maxArgon2Iterations, Memory: intPtr(maxArgon2MemoryKiB), Parallelism: intPtr(maxArgon2Parallelism)},
+		},
+		{name: "argon2id t too low", params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations - 1, Memory: intPtr(minArgon2MemoryKiB), Parallelism: intPtr(1)}, wantErr: true},
+		{name: "argon2id t too high", params: kdfParams{Algorithm: kdfArgon2id, Iterations: maxArgon2Iterations + 1, Memory: intPtr(minArgon2MemoryKiB), Parallelism: intPtr(1)}, wantErr: true},
+		{name: "argon2id m missing", params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Parallelism: intPtr(1)}, wantErr: true},
+		{name: "argon2id m too low", params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Memory: intPtr(minArgon2MemoryKiB - 1), Parallelism: intPtr(1)}, wantErr: true},
+		{name: "argon2id m too high", params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Memory: intPtr(maxArgon2MemoryKiB + 1), Parallelism: intPtr(1)}, wantErr: true},
+		{name: "argon2id p missing", params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Memory: intPtr(minArgon2MemoryKiB)}, wantErr: true},
+		{name: "argon2id p zero", params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Memory: intPtr(minArgon2MemoryKiB), Parallelism: intPtr(0)}, wantErr: true},
+		{name: "argon2id p too high", params: kdfParams{Algorithm: kdfArgon2id, Iterations: minArgon2Iterations, Memory: intPtr(minArgon2MemoryKiB), Parallelism: intPtr(maxArgon2Parallelism + 1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.params.normalize()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("normalize(%+v) accepted", tt.params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalize(%+v): %v", tt.params, err)
+			}
+			if got.Algorithm != tt.want.Algorithm || got.Iterations != tt.want.Iterations ||
+				!equalIntPtr(got.Memory, tt.want.Memory) || !equalIntPtr(got.Parallelism, tt.want.Parallelism) {
+				t.Errorf("normalize(%+v) = %+v, want %+v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func equalIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"passkeys/internal/middleware"
+)
+
+// Пара ключей пользователя нужна, чтобы другие могли передать ему свой ключ хранилища
+// (экстренный доступ). Ключи создаёт клиент; закрытый ключ сервер получает уже зашифрованным.
+
+type keyPairRequest struct {
+	// PublicKey — SubjectPublicKeyInfo (DER) в base64.
+	PublicKey        string `json:"publicKey"`
+	PrivateKeyCipher string `json:"privateKeyCipher"`
+	PrivateKeyNonce  string `json:"privateKeyNonce"`
+	// Заменить уже заданную пару можно только с мастер-паролем.
+	reauthRequest
+}
+
+type keyPairResponse struct {
+	PublicKey        string `json:"publicKey"`
+	PrivateKeyCipher string `json:"privateKeyCipher"`
+	PrivateKeyNonce  string `json:"privateKeyNonce"`
+}
+
+func (h *AuthHandler) GetKeyPair(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var publicKey, privateKeyCipher, privateKeyNonce []byte
+	if err := h.DB.QueryRow(r.Context(),
+		"select public_key, private_key_cipher, private_key_nonce from users where id=$1", user.ID,
+	).Scan(&publicKey, &privateKeyCipher, &privateKeyNonce); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if publicKey == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, keyPairResponse{
+		PublicKey:        base64.StdEncoding.EncodeToString(publicKey),
+		PrivateKeyCipher: base64.StdEncoding.EncodeToString(privateKeyCipher),
+		PrivateKeyNonce:  base64.StdEncoding.EncodeToString(privateKeyNonce),
+	})
+}
+
+// SetKeyPair сохраняет пару ключей. При замене открытого ключа ключи хранилищ, уже
+// зашифрованные для старого, становятся бесполезны: такие доверенности ждут нового подтверждения.
+func (h *AuthHandler) SetKeyPair(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req keyPairRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(req.PublicKey)
+	if err != nil || len(publicKey) == 0 {
+		http.Error(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	if _, err := x509.ParsePKIXPublicKey(publicKey); err != nil {
+		http.Error(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	privateKeyCipher, err := base64.StdEncoding.DecodeString(req.PrivateKeyCipher)
+	if err != nil || len(privateKeyCipher) == 0 {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	privateKeyNonce, err := base64.StdEncoding.DecodeString(req.PrivateKeyNonce)
+	if err != nil || len(privateKeyNonce) == 0 {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var hasKey bool
+	if err := h.DB.QueryRow(ctx, "select public_key is not null from users where id=$1", user.ID).Scan(&hasKey); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if hasKey && !h.requireReauth(w, r, user.ID, req.reauthRequest) {
+		return
+	}
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"update users set public_key=$1, private_key_cipher=$2, private_key_nonce=$3 where id=$4",
+		publicKey, privateKeyCipher, privateKeyNonce, user.ID,
+	); err != nil {
+		log.Printf("[SetKeyPair] db error (update): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if hasKey {
+		if _, err := tx.Exec(ctx, `
+			update emergency_access set status=$1, key_encrypted=null, recovery_initiated_at=null, updated_at=now()
+			where grantee_id=$2 and status<>$3`,
+			emergencyStatusAccepted, user.ID, emergencyStatusInvited,
+		); err != nil {
+			log.Printf("[SetKeyPair] db error (reset emergency access): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
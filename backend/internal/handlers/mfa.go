@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"passkeys/internal/auth"
+	"passkeys/internal/webauthn"
+)
+
+// Способы второго фактора, которые Login перечисляет в mfaRequiredResponse.
+const (
+	mfaMethodTOTP     = "totp"
+	mfaMethodWebAuthn = "webauthn"
+	// mfaMethodRecoveryCode предлагается только вместе с настоящим вторым фактором.
+	mfaMethodRecoveryCode = "recovery_code"
+)
+
+type mfaRequiredResponse struct {
+	MFARequired bool     `json:"mfaRequired"`
+	MFAToken    string   `json:"mfaToken"`
+	Methods     []string `json:"methods"`
+}
+
+// mfaLoginRequest несёт ровно один второй фактор: код TOTP, ответ ключа безопасности
+// или код восстановления.
+type mfaLoginRequest struct {
+	MFAToken     string                      `json:"mfaToken"`
+	Code         string                      `json:"code,omitempty"`
+	Assertion    *webauthn.AssertionResponse `json:"assertion,omitempty"`
+	RecoveryCode string                      `json:"recoveryCode,omitempty"`
+}
+
+// factorCount — сколько вторых факторов передано в запросе.
+func (req mfaLoginRequest) factorCount() int {
+	count := 0
+	if req.Code != "" {
+		count++
+	}
+	if req.Assertion != nil {
+		count++
+	}
+	if req.RecoveryCode != "" {
+		count++
+	}
+	return count
+}
+
+// mfaMethods возвращает подключённые у пользователя вторые факторы; пустой список — 2FA выключена.
+func (h *AuthHandler) mfaMethods(ctx context.Context, userID string) ([]string, error) {
+	methods := make([]string, 0, 3)
+
+	var totpEnabled bool
+	if err := h.DB.QueryRow(ctx,
+		"select exists(select 1 from user_totp where user_id=$1 and confirmed_at is not null)", userID,
+	).Scan(&totpEnabled); err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		methods = append(methods, mfaMethodTOTP)
+	}
+
+	webauthnEnabled, err := h.hasWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if webauthnEnabled {
+		methods = append(methods, mfaMethodWebAuthn)
+	}
+
+	if len(methods) > 0 {
+		remaining, err := h.remainingRecoveryCodes(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if remaining > 0 {
+			methods = append(methods, mfaMethodRecoveryCode)
+		}
+	}
+
+	return methods, nil
+}
+
+// LoginMFA — второй шаг входа: обменивает mfa-токен из Login и код второго фактора на пару токенов.
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req mfaLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.MFAToken == "" || req.factorCount() != 1 {
+		http.Error(w, "invalid credentials", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := auth.ParseMFAToken(h.Keys, req.MFAToken)
+	if err != nil {
+		http.Error(w, "invalid mfa token", http.StatusUnauthorized)
+		return
+	}
+
+	throttleKeys := []string{ipThrottleKey(r), userThrottleKey(claims.UserID)}
+	if h.throttled(w, r, throttleKeys...) {
+		return
+	}
+
+	ctx := r.Context()
+	var ok bool
+	switch {
+	case req.Assertion != nil:
+		var ownerID string
+		ownerID, ok, err = h.verifyWebAuthnAssertion(ctx, *req.Assertion, challengeMFA, false)
+		ok = ok && ownerID == claims.UserID
+	case req.RecoveryCode != "":
+		ok, err = h.useRecoveryCode(ctx, claims.UserID, req.RecoveryCode)
+	default:
+		ok, err = h.verifyTOTP(ctx, claims.UserID, req.Code)
+	}
+	if err != nil {
+		log.Printf("[LoginMFA] db error (verify second factor): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.recordFailure(w, r, throttleKeys...)
+		http.Error(w, "invalid second factor", http.StatusUnauthorized)
+		return
+	}
+	h.resetFailures(r, userThrottleKey(claims.UserID))
+
+	user, err := h.findUserByID(ctx, claims.UserID)
+	if err != nil {
+		http.Error(w, "invalid mfa token", http.StatusUnauthorized)
+		return
+	}
+
+	h.issueSession(w, r, user)
+}
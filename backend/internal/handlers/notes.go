@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -12,19 +13,23 @@ import (
 	"passkeys/internal/middleware"
 )
 
+// NoteHandler — представление /notes над записями типа note.
 type NoteHandler struct {
 	DB *pgxpool.Pool
 }
 
 type noteRequest struct {
-	TitleCipher string `json:"titleCipher"`
-	TitleNonce  string `json:"titleNonce"`
-	TextCipher  string `json:"textCipher"`
-	TextNonce   string `json:"textNonce"`
+	// FolderID — пустая строка переносит запись в корень; без поля папка не меняется.
+	FolderID    *string `json:"folderId,omitempty"`
+	TitleCipher string  `json:"titleCipher"`
+	TitleNonce  string  `json:"titleNonce"`
+	TextCipher  string  `json:"textCipher"`
+	TextNonce   string  `json:"textNonce"`
 }
 
 type noteResponse struct {
 	ID          string    `json:"id"`
+	FolderID    *string   `json:"folderId"`
 	TitleCipher string    `json:"titleCipher"`
 	TitleNonce  string    `json:"titleNonce"`
 	TextCipher  string    `json:"textCipher"`
@@ -40,45 +45,30 @@ func (h *NoteHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := h.DB.Query(r.Context(), `
-		select id, title_cipher, title_nonce, text_cipher, text_nonce, created_at, updated_at
-		from notes where user_id=$1 order by updated_at desc`, user.ID)
+	notes, err := listNotes(r.Context(), h.DB, user.ID, r.URL.Query().Get("folder"))
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	notes := make([]noteResponse, 0)
-	for rows.Next() {
-		var item noteResponse
-		var titleCipher []byte
-		var titleNonce []byte
-		var textCipher []byte
-		var textNonce []byte
-		if err := rows.Scan(
-			&item.ID,
-			&titleCipher,
-			&titleNonce,
-			&textCipher,
-			&textNonce,
-			&item.CreatedAt,
-			&item.UpdatedAt,
-		); err != nil {
-			http.Error(w, "db error", http.StatusInternalServerError)
-			return
-		}
-
-		item.TitleCipher = base64.StdEncoding.EncodeToString(titleCipher)
-		item.TitleNonce = base64.StdEncoding.EncodeToString(titleNonce)
-		item.TextCipher = base64.StdEncoding.EncodeToString(textCipher)
-		item.TextNonce = base64.StdEncoding.EncodeToString(textNonce)
-		notes = append(notes, item)
-	}
 
 	respondJSON(w, notes)
 }
 
+// listNotes возвращает записи пользователя в том виде, в каком их отдаёт API;
+// folder — id папки, folderRoot или пусто (все записи).
+func listNotes(ctx context.Context, db *pgxpool.Pool, userID, folder string) ([]noteResponse, error) {
+	items, err := listItems(ctx, db, userID, itemFilter{Type: itemTypeNote, Folder: folder})
+	if err != nil {
+		return nil, err
+	}
+
+	notes := make([]noteResponse, 0, len(items))
+	for _, item := range items {
+		notes = append(notes, noteFromItem(item))
+	}
+	return notes, nil
+}
+
 func (h *NoteHandler) Create(w http.ResponseWriter, r *http.Request) {
 	user, ok := middleware.GetUser(r)
 	if !ok {
@@ -96,37 +86,23 @@ func (h *NoteHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	titleCipher, titleNonce, textCipher, textNonce, err := decodeNote(req)
-	if err != nil {
+	item := req.item()
+	if err := item.validate(); err != nil {
 		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	var response noteResponse
-	err = h.DB.QueryRow(r.Context(), `
-		insert into notes (user_id, title_cipher, title_nonce, text_cipher, text_nonce)
-		values ($1, $2, $3, $4, $5)
-		returning id, title_cipher, title_nonce, text_cipher, text_nonce, created_at, updated_at`,
-		user.ID, titleCipher, titleNonce, textCipher, textNonce,
-	).Scan(
-		&response.ID,
-		&titleCipher,
-		&titleNonce,
-		&textCipher,
-		&textNonce,
-		&response.CreatedAt,
-		&response.UpdatedAt,
-	)
+	created, err := createItem(r.Context(), h.DB, user.ID, item)
+	if errors.Is(err, errUnknownFolder) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
 
-	response.TitleCipher = base64.StdEncoding.EncodeToString(titleCipher)
-	response.TitleNonce = base64.StdEncoding.EncodeToString(titleNonce)
-	response.TextCipher = base64.StdEncoding.EncodeToString(textCipher)
-	response.TextNonce = base64.StdEncoding.EncodeToString(textNonce)
-	respondJSON(w, response)
+	respondJSON(w, noteFromItem(created))
 }
 
 func (h *NoteHandler) Update(w http.ResponseWriter, r *http.Request) {
@@ -148,40 +124,26 @@ func (h *NoteHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	titleCipher, titleNonce, textCipher, textNonce, err := decodeNote(req)
-	if err != nil {
+	item := req.item()
+	if err := item.validate(); err != nil {
 		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	var response noteResponse
-	err = h.DB.QueryRow(r.Context(), `
-		update notes
-		set title_cipher=$1, title_nonce=$2, text_cipher=$3, text_nonce=$4, updated_at=now()
-		where id=$5 and user_id=$6
-		returning id, title_cipher, title_nonce, text_cipher, text_nonce, created_at, updated_at`,
-		titleCipher, titleNonce, textCipher, textNonce, noteID, user.ID,
-	).Scan(
-		&response.ID,
-		&titleCipher,
-		&titleNonce,
-		&textCipher,
-		&textNonce,
-		&response.CreatedAt,
-		&response.UpdatedAt,
-	)
+	updated, err := mergeItem(r.Context(), h.DB, user.ID, noteID, item)
+	if errors.Is(err, errUnknownFolder) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
 
-	response.TitleCipher = base64.StdEncoding.EncodeToString(titleCipher)
-	response.TitleNonce = base64.StdEncoding.EncodeToString(titleNonce)
-	response.TextCipher = base64.StdEncoding.EncodeToString(textCipher)
-	response.TextNonce = base64.StdEncoding.EncodeToString(textNonce)
-	respondJSON(w, response)
+	respondJSON(w, noteFromItem(updated))
 }
 
+// Delete переносит заметку в корзину.
 func (h *NoteHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	user, ok := middleware.GetUser(r)
 	if !ok {
@@ -195,12 +157,12 @@ func (h *NoteHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	commandTag, err := h.DB.Exec(r.Context(), "delete from notes where id=$1 and user_id=$2", noteID, user.ID)
+	deleted, err := trashItem(r.Context(), h.DB, user.ID, noteID, itemTypeNote)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
-	if commandTag.RowsAffected() == 0 {
+	if !deleted {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
@@ -208,22 +170,26 @@ func (h *NoteHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func decodeNote(req noteRequest) ([]byte, []byte, []byte, []byte, error) {
-	titleCipher, err := base64.StdEncoding.DecodeString(req.TitleCipher)
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	titleNonce, err := base64.StdEncoding.DecodeString(req.TitleNonce)
-	if err != nil {
-		return nil, nil, nil, nil, err
+func (req noteRequest) item() itemRequest {
+	return itemRequest{
+		Type:     itemTypeNote,
+		FolderID: req.FolderID,
+		Payload: itemPayload{
+			"title": {Cipher: req.TitleCipher, Nonce: req.TitleNonce},
+			"text":  {Cipher: req.TextCipher, Nonce: req.TextNonce},
+		},
 	}
-	textCipher, err := base64.StdEncoding.DecodeString(req.TextCipher)
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	textNonce, err := base64.StdEncoding.DecodeString(req.TextNonce)
-	if err != nil {
-		return nil, nil, nil, nil, err
+}
+
+func noteFromItem(item itemResponse) noteResponse {
+	return noteResponse{
+		ID:          item.ID,
+		FolderID:    item.FolderID,
+		TitleCipher: item.Payload["title"].Cipher,
+		TitleNonce:  item.Payload["title"].Nonce,
+		TextCipher:  item.Payload["text"].Cipher,
+		TextNonce:   item.Payload["text"].Nonce,
+		CreatedAt:   item.CreatedAt,
+		UpdatedAt:   item.UpdatedAt,
 	}
-	return titleCipher, titleNonce, textCipher, textNonce, nil
 }
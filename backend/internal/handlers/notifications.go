@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"passkeys/internal/mail"
+	"passkeys/internal/middleware"
+)
+
+// emailCodeLifetime — сколько действуют коды из писем (подтверждение адреса, смена email).
+const emailCodeLifetime = time.Hour
+
+// mailTimeout ограничивает фоновую отправку одного письма.
+const mailTimeout = 30 * time.Second
+
+type emailCodeData struct {
+	Code           string
+	ExpiresMinutes int
+}
+
+type securityNoticeData struct {
+	Time      time.Time
+	IP        string
+	UserAgent string
+	Device    string
+}
+
+func newEmailCodeData(code string) emailCodeData {
+	return emailCodeData{Code: code, ExpiresMinutes: int(emailCodeLifetime.Minutes())}
+}
+
+func newSecurityNoticeData(r *http.Request) securityNoticeData {
+	return securityNoticeData{
+		Time:      time.Now().UTC(),
+		IP:        clientIP(r),
+		UserAgent: truncate(r.UserAgent(), maxUserAgentLength),
+		Device:    truncate(r.Header.Get(deviceNameHeader), maxDeviceNameLength),
+	}
+}
+
+// sendMail собирает письмо по шаблону и отправляет его, дожидаясь результата.
+func (h *AuthHandler) sendMail(ctx context.Context, to, template string, data any) error {
+	msg, err := mail.Render(to, template, data)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(ctx, msg)
+}
+
+// sendMailInBackground не задерживает ответ на время SMTP: ошибка только пишется в лог.
+func (h *AuthHandler) sendMailInBackground(to, template string, data any) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := h.sendMail(ctx, to, template, data); err != nil {
+			log.Printf("[mail] send error (%s): %v", template, err)
+		}
+	}()
+}
+
+// notify отправляет уведомление безопасности. На неподтверждённый адрес писем не шлём:
+// иначе, зарегистрировавшись на чужой email, можно было бы засыпать его уведомлениями.
+func (h *AuthHandler) notify(user userCredentials, template string, data any) {
+	if !user.EmailVerified {
+		return
+	}
+	h.sendMailInBackground(user.Email, template, data)
+}
+
+// rememberDevice запоминает устройство входа и сообщает, новое ли оно для пользователя,
+// у которого уже были другие. Первое устройство (регистрация) новым не считается.
+func (h *AuthHandler) rememberDevice(ctx context.Context, r *http.Request, userID string) (bool, error) {
+	deviceHash := hashToken(truncate(r.UserAgent(), maxUserAgentLength) + "\n" + truncate(r.Header.Get(deviceNameHeader), maxDeviceNameLength))
+
+	commandTag, err := h.DB.Exec(ctx,
+		"update login_devices set last_seen_at=now() where user_id=$1 and device_hash=$2", userID, deviceHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	if commandTag.RowsAffected() > 0 {
+		return false, nil
+	}
+
+	var hasOthers bool
+	if err := h.DB.QueryRow(ctx, "select exists(select 1 from login_devices where user_id=$1)", userID).Scan(&hasOthers); err != nil {
+		return false, err
+	}
+	if _, err := h.DB.Exec(ctx,
+		"insert into login_devices (user_id, device_hash) values ($1, $2) on conflict do nothing", userID, deviceHash,
+	); err != nil {
+		return false, err
+	}
+	return hasOthers, nil
+}
+
+// createEmailVerification выдаёт новый код подтверждения адреса, заменяя прежний.
+func (h *AuthHandler) createEmailVerification(ctx context.Context, userID string) (string, error) {
+	code, err := generateRecoveryCode()
+	if err != nil {
+		return "", err
+	}
+	_, err = h.DB.Exec(ctx, `
+		insert into email_verifications (user_id, token_hash, expires_at) values ($1, $2, $3)
+		on conflict (user_id) do update
+		set token_hash=excluded.token_hash, expires_at=excluded.expires_at, created_at=now()`,
+		userID, hashToken(normalizeRecoveryCode(code)), time.Now().Add(emailCodeLifetime),
+	)
+	return code, err
+}
+
+// VerifyEmail подтверждает адрес кодом из письма, отправленного при регистрации.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req confirmEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userKey := userThrottleKey(user.ID)
+	if h.throttled(w, r, userKey) {
+		return
+	}
+
+	ctx := r.Context()
+	var userID string
+	err := h.DB.QueryRow(ctx,
+		"delete from email_verifications where user_id=$1 and token_hash=$2 and expires_at>now() returning user_id",
+		user.ID, hashToken(normalizeRecoveryCode(req.Code)),
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.recordFailure(w, r, userKey)
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	h.resetFailures(r, userKey)
+
+	if _, err := h.DB.Exec(ctx, "update users set email_verified_at=now() where id=$1", user.ID); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendEmailVerification отправляет новый код подтверждения адреса.
+func (h *AuthHandler) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mailKey := mailThrottleKey(user.ID)
+	if h.throttled(w, r, mailKey) {
+		return
+	}
+
+	ctx := r.Context()
+	creds, err := h.findUserByID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if creds.EmailVerified {
+		http.Error(w, "email already verified", http.StatusConflict)
+		return
+	}
+	h.recordFailure(w, r, mailKey)
+
+	code, err := h.createEmailVerification(ctx, user.ID)
+	if err != nil {
+		log.Printf("[ResendEmailVerification] db error (create): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.sendMail(ctx, creds.Email, mail.TemplateVerifyEmail, newEmailCodeData(code)); err != nil {
+		log.Printf("[ResendEmailVerification] mail error: %v", err)
+		http.Error(w, "mail error", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"passkeys/internal/middleware"
+	"passkeys/internal/oidc"
+)
+
+// Вход через OpenID Connect заменяет только проверку личности при входе в API:
+// ключ хранилища по-прежнему открывается мастер-паролем на клиенте, поэтому authResponse
+// тот же, что и при обычном входе. Второй фактор при таком входе обеспечивает провайдер.
+
+// oidcLoginLifetime — сколько ждём возврата пользователя от провайдера.
+const oidcLoginLifetime = 10 * time.Minute
+
+type oidcBeginResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+	// State клиент сохраняет и сверяет с параметром state на странице возврата.
+	State string `json:"state"`
+}
+
+type oidcFinishRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+type oidcIdentityResponse struct {
+	ID          string     `json:"id"`
+	Issuer      string     `json:"issuer"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
+
+// OIDCBegin начинает вход через провайдера: возвращает адрес, куда отправить пользователя.
+func (h *AuthHandler) OIDCBegin(w http.ResponseWriter, r *http.Request) {
+	if !h.oidcEnabled(w) {
+		return
+	}
+	h.beginOIDC(w, r, nil)
+}
+
+// OIDCFinish завершает вход кодом, с которым провайдер вернул пользователя. Учётная запись
+// провайдера находится по (issuer, sub); при первом входе она привязывается к пользователю
+// с тем же email, если адрес подтверждён и у провайдера, и у нас.
+func (h *AuthHandler) OIDCFinish(w http.ResponseWriter, r *http.Request) {
+	if !h.oidcEnabled(w) {
+		return
+	}
+
+	var req oidcFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.State == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	ipKey := ipThrottleKey(r)
+	if h.throttled(w, r, ipKey) {
+		return
+	}
+
+	ctx := r.Context()
+	linkUserID, token, ok := h.finishOIDC(w, r, req, ipKey)
+	if !ok {
+		return
+	}
+	if linkUserID != nil {
+		// Вход, начатый для привязки, завершается только через OIDCLinkFinish.
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+
+	var userID string
+	err := h.DB.QueryRow(ctx,
+		"update oidc_identities set last_login_at=now(), email=$3 where issuer=$1 and subject=$2 returning user_id",
+		token.Issuer, token.Subject, token.Email,
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) && h.OIDCLinkByEmail {
+		userID, err = h.linkOIDCByEmail(ctx, token)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.recordFailure(w, r, ipKey)
+		http.Error(w, "no account linked to this identity", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("[OIDCFinish] db error (identity): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.findUserByID(ctx, userID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	// Провайдер подтверждает только первый фактор: подключённая у нас 2FA спрашивается как при входе по паролю.
+	h.issueSessionOrMFA(w, r, user)
+}
+
+// linkOIDCByEmail привязывает новую учётную запись провайдера к пользователю с тем же
+// подтверждённым адресом. pgx.ErrNoRows — подходящего пользователя нет.
+func (h *AuthHandler) linkOIDCByEmail(ctx context.Context, token oidc.IDToken) (string, error) {
+	if !token.EmailVerified || token.Email == "" {
+		return "", pgx.ErrNoRows
+	}
+	var userID string
+	err := h.DB.QueryRow(ctx, `
+		insert into oidc_identities (user_id, issuer, subject, email, last_login_at)
+		select id, $1, $2, $3, now() from users where lower(email)=lower($3) and email_verified_at is not null
+		returning user_id`,
+		token.Issuer, token.Subject, token.Email,
+	).Scan(&userID)
+	return userID, err
+}
+
+// OIDCLinkBegin начинает привязку учётной записи провайдера к вошедшему пользователю.
+func (h *AuthHandler) OIDCLinkBegin(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !h.oidcEnabled(w) {
+		return
+	}
+	h.beginOIDC(w, r, &user.ID)
+}
+
+// OIDCLinkFinish привязывает учётную запись. Требует ту же сессию, что начала привязку:
+// иначе чужую учётную запись провайдера можно было бы подсунуть в свой аккаунт.
+func (h *AuthHandler) OIDCLinkFinish(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !h.oidcEnabled(w) {
+		return
+	}
+
+	var req oidcFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.State == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userKey := userThrottleKey(user.ID)
+	if h.throttled(w, r, userKey) {
+		return
+	}
+
+	linkUserID, token, ok := h.finishOIDC(w, r, req, userKey)
+	if !ok {
+		return
+	}
+	if linkUserID == nil || *linkUserID != user.ID {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+
+	var identity oidcIdentityResponse
+	err := h.DB.QueryRow(r.Context(), `
+		insert into oidc_identities (user_id, issuer, subject, email) values ($1, $2, $3, $4)
+		returning id, issuer, email, created_at, last_login_at`,
+		user.ID, token.Issuer, token.Subject, token.Email,
+	).Scan(&identity.ID, &identity.Issuer, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			http.Error(w, "identity already linked", http.StatusConflict)
+			return
+		}
+		log.Printf("[OIDCLinkFinish] db error (insert): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, identity)
+}
+
+func (h *AuthHandler) ListOIDCIdentities(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(), `
+		select id, issuer, email, created_at, last_login_at
+		from oidc_identities where user_id=$1 order by created_at`, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	identities := make([]oidcIdentityResponse, 0)
+	for rows.Next() {
+		var item oidcIdentityResponse
+		if err := rows.Scan(&item.ID, &item.Issuer, &item.Email, &item.CreatedAt, &item.LastLoginAt); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		identities = append(identities, item)
+	}
+
+	respondJSON(w, identities)
+}
+
+func (h *AuthHandler) UnlinkOIDCIdentity(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	identityID := chi.URLParam(r, "id")
+	if identityID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	commandTag, err := h.DB.Exec(r.Context(), "delete from oidc_identities where id::text=$1 and user_id=$2", identityID, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) oidcEnabled(w http.ResponseWriter) bool {
+	if h.OIDC == nil {
+		http.Error(w, "sso not configured", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// beginOIDC сохраняет state, nonce и code_verifier и отвечает адресом провайдера.
+func (h *AuthHandler) beginOIDC(w http.ResponseWriter, r *http.Request, linkUserID *string) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "state generation failed", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "state generation failed", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "state generation failed", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	authorizationURL, err := h.OIDC.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		log.Printf("[OIDC] provider error: %v", err)
+		http.Error(w, "sso provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	_, _ = h.DB.Exec(ctx, "delete from oidc_logins where expires_at<now()")
+
+	if _, err := h.DB.Exec(ctx,
+		"insert into oidc_logins (state_hash, user_id, nonce, code_verifier, expires_at) values ($1, $2, $3, $4, $5)",
+		hashToken(state), linkUserID, nonce, verifier, time.Now().Add(oidcLoginLifetime),
+	); err != nil {
+		log.Printf("[OIDC] db error (insert login): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, oidcBeginResponse{AuthorizationURL: authorizationURL, State: state})
+}
+
+// finishOIDC одноразово забирает начатый вход по state, обменивает код и проверяет ID-токен.
+// Возвращает user_id, для которого начата привязка (nil — обычный вход). При неудаче
+// сам пишет ответ и учитывает попытку по failKey.
+func (h *AuthHandler) finishOIDC(w http.ResponseWriter, r *http.Request, req oidcFinishRequest, failKey string) (*string, oidc.IDToken, bool) {
+	ctx := r.Context()
+
+	var (
+		linkUserID      *string
+		nonce, verifier string
+	)
+	err := h.DB.QueryRow(ctx,
+		"delete from oidc_logins where state_hash=$1 and expires_at>now() returning user_id, nonce, code_verifier",
+		hashToken(req.State),
+	).Scan(&linkUserID, &nonce, &verifier)
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.recordFailure(w, r, failKey)
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return nil, oidc.IDToken{}, false
+	}
+	if err != nil {
+		log.Printf("[OIDC] db error (consume login): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return nil, oidc.IDToken{}, false
+	}
+
+	token, err := h.OIDC.Exchange(ctx, strings.TrimSpace(req.Code), verifier, nonce)
+	if err != nil {
+		log.Printf("[OIDC] exchange failed: %v", err)
+		h.recordFailure(w, r, failKey)
+		http.Error(w, "sso login failed", http.StatusUnauthorized)
+		return nil, oidc.IDToken{}, false
+	}
+	return linkUserID, token, true
+}
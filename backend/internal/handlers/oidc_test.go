@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"passkeys/internal/auth"
+	"passkeys/internal/oidc"
+	"passkeys/internal/oidc/oidctest"
+)
+
+// openTestDB подключается к TEST_DATABASE_URL и накатывает миграции в отдельную схему,
+// которая удаляется после теста. Без TEST_DATABASE_URL тест пропускается.
+func openTestDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Exec(ctx, "create schema "+schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(context.Background(), "drop schema "+schema+" cascade"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+		admin.Close()
+	})
+
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	migrations, err := filepath.Glob("../../../db/migrations/*.sql")
+	if err != nil || len(migrations) == 0 {
+		t.Fatalf("migrations not found: %v", err)
+	}
+	sort.Strings(migrations)
+	for _, path := range migrations {
+		sql, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Без аргументов pgx выполняет запрос простым протоколом, так что файл может содержать несколько команд.
+		if _, err := pool.Exec(ctx, string(sql)); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+	}
+	return pool
+}
+
+type oidcTestEnv struct {
+	t       *testing.T
+	pool    *pgxpool.Pool
+	idp     *oidctest.Provider
+	handler *AuthHandler
+	router  http.Handler
+}
+
+func newOIDCTestEnv(t *testing.T) *oidcTestEnv {
+	t.Helper()
+	pool := openTestDB(t)
+
+	idp, err := oidctest.New("passkeys", "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(idp)
+	t.Cleanup(server.Close)
+	idp.Issuer = server.URL
+
+	signingKey, err := auth.GenerateSigningKey(auth.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &AuthHandler{
+		DB:                   pool,
+		Secret:               []byte("test secret"),
+		Keys:                 auth.NewKeySet(signingKey),
+		AccessTokenLifetime:  time.Minute,
+		RefreshTokenLifetime: time.Hour,
+		Hasher:               auth.NewArgon2idHasher(),
+		OIDC: oidc.New(oidc.Config{
+			Issuer:       server.URL,
+			ClientID:     "passkeys",
+			ClientSecret: "test-secret",
+			RedirectURL:  "https://app.example.com/oidc/callback",
+			HTTPClient:   server.Client(),
+		}),
+	}
+	router := chi.NewRouter()
+	router.Post("/auth/oidc/begin", h.OIDCBegin)
+	router.Post("/auth/oidc/finish", h.OIDCFinish)
+
+	return &oidcTestEnv{t: t, pool: pool, idp: idp, handler: h, router: router}
+}
+
+func (e *oidcTestEnv) createUser(email string, verified bool) string {
+	e.t.Helper()
+	var userID string
+	err := e.pool.QueryRow(context.Background(), `
+		insert into users (email, password_hash, kdf_salt, auth_scheme, email_verified_at)
+		values ($1, 'unused', '\x00', $2, case when $3 then now() end)
+		returning id`,
+		email, authSchemeAuthHash, verified,
+	).Scan(&userID)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return userID
+}
+
+func (e *oidcTestEnv) post(path string, body any) *httptest.ResponseRecorder {
+	e.t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "oidc-test")
+	rec := httptest.NewRecorder()
+	e.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// login проходит вход через SSO как браузер: begin, провайдер, finish.
+func (e *oidcTestEnv) login() *httptest.ResponseRecorder {
+	e.t.Helper()
+	rec := e.post("/auth/oidc/begin", struct{}{})
+	if rec.Code != http.StatusOK {
+		e.t.Fatalf("begin: %d %s", rec.Code, rec.Body)
+	}
+	var begin oidcBeginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&begin); err != nil {
+		e.t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(begin.AuthorizationURL)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		e.t.Fatalf("authorize: %s %v", resp.Status, err)
+	}
+	if location.Query().Get("state") != begin.State {
+		e.t.Fatalf("state = %q, want %q", location.Query().Get("state"), begin.State)
+	}
+
+	return e.post("/auth/oidc/finish", oidcFinishRequest{Code: location.Query().Get("code"), State: begin.State})
+}
+
+// identityUser возвращает пользователя, к которому привязана учётная запись (issuer, sub).
+func (e *oidcTestEnv) identityUser(subject string) (userID, email string, found bool) {
+	e.t.Helper()
+	err := e.pool.QueryRow(context.Background(),
+		"select user_id::text, email from oidc_identities where issuer=$1 and subject=$2",
+		e.idp.Issuer, subject,
+	).Scan(&userID, &email)
+	if err != nil {
+		return "", "", false
+	}
+	return userID, email, true
+}
+
+func TestOIDCFinishMapsSubjectToUser(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.handler.OIDCLinkByEmail = true
+	userID := env.createUser("sso@example.com", true)
+	env.createUser("other@example.com", true)
+
+	// Первый вход: учётная запись провайдера привязывается по подтверждённому email.
+	env.idp.User = oidctest.User{Subject: "subject-1", Email: "sso@example.com", EmailVerified: true}
+	rec := env.login()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first login: %d %s", rec.Code, rec.Body)
+	}
+	var session authResponse
+	if err := json.NewDecoder(rec.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Email != "sso@example.com" || session.Token == "" || session.RefreshToken == "" {
+		t.Fatalf("session = %+v", session)
+	}
+	if got, _, found := env.identityUser("subject-1"); !found || got != userID {
+		t.Fatalf("identity user = %q (found %v), want %q", got, found, userID)
+	}
+
+	// Следующие входы ищут пользователя по (issuer, sub), даже если у провайдера сменился адрес.
+	env.idp.User.Email = "other@example.com"
+	rec = env.login()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second login: %d %s", rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Email != "sso@example.com" {
+		t.Errorf("second login as %q, want sso@example.com", session.Email)
+	}
+	got, email, found := env.identityUser("subject-1")
+	if !found || got != userID || email != "other@example.com" {
+		t.Errorf("identity = %q %q (found %v), want %q with the new email", got, email, found, userID)
+	}
+}
+
+func TestOIDCFinishRejectsUnlinkedIdentity(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.createUser("unverified@example.com", false)
+	env.createUser("verified@example.com", true)
+
+	tests := []struct {
+		name        string
+		user        oidctest.User
+		linkByEmail bool
+	}{
+		{"unknown email", oidctest.User{Subject: "subject-2", Email: "nobody@example.com", EmailVerified: true}, true},
+		{"email not verified by us", oidctest.User{Subject: "subject-3", Email: "unverified@example.com", EmailVerified: true}, true},
+		{"email not verified by provider", oidctest.User{Subject: "subject-4", Email: "unverified@example.com"}, true},
+		{"link by email disabled", oidctest.User{Subject: "subject-5", Email: "verified@example.com", EmailVerified: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(parent *testing.T) { env.t = parent }(env.t)
+			env.t = t
+			env.handler.OIDCLinkByEmail = tt.linkByEmail
+			env.idp.User = tt.user
+			if rec := env.login(); rec.Code != http.StatusForbidden {
+				t.Fatalf("login: %d %s, want 403", rec.Code, rec.Body)
+			}
+			if _, _, found := env.identityUser(tt.user.Subject); found {
+				t.Error("identity linked")
+			}
+		})
+	}
+}
+
+func TestOIDCFinishRequiresSecondFactor(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	ctx := context.Background()
+	userID := env.createUser("mfa@example.com", true)
+	if _, err := env.pool.Exec(ctx,
+		"insert into oidc_identities (user_id, issuer, subject, email) values ($1, $2, 'subject-mfa', 'mfa@example.com')",
+		userID, env.idp.Issuer,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.pool.Exec(ctx,
+		`insert into user_totp (user_id, secret_cipher, secret_nonce, confirmed_at) values ($1, '\x00', '\x00', now())`, userID,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	env.idp.User = oidctest.User{Subject: "subject-mfa", Email: "mfa@example.com", EmailVerified: true}
+	rec := env.login()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		mfaRequiredResponse
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.MFARequired || resp.Token != "" || len(resp.Methods) != 1 || resp.Methods[0] != mfaMethodTOTP {
+		t.Fatalf("response = %+v, want mfaRequired with totp", resp)
+	}
+	claims, err := auth.ParseMFAToken(env.handler.Keys, resp.MFAToken)
+	if err != nil || claims.UserID != userID {
+		t.Fatalf("mfa token: %+v %v", claims, err)
+	}
+
+	var sessions int
+	if err := env.pool.QueryRow(ctx, "select count(*) from sessions where user_id=$1", userID).Scan(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 0 {
+		t.Errorf("%d sessions opened before the second factor", sessions)
+	}
+}
+
+func TestOIDCFinishRejectsUnknownState(t *testing.T) {
+	env := newOIDCTestEnv(t)
+
+	if rec := env.post("/auth/oidc/finish", oidcFinishRequest{Code: "code", State: "never issued"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown state: %d %s, want 400", rec.Code, rec.Body)
+	}
+	if rec := env.post("/auth/oidc/finish", oidcFinishRequest{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty request: %d %s, want 400", rec.Code, rec.Body)
+	}
+}
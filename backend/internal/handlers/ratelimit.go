@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"passkeys/internal/throttle"
+)
+
+// clientIP — адрес клиента без порта. За прокси RemoteAddr подменяет chi middleware.RealIP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ipThrottleKey(r *http.Request) string {
+	return throttle.Key("ip", clientIP(r))
+}
+
+func emailThrottleKey(email string) string {
+	return throttle.Key("email", strings.ToLower(email))
+}
+
+func userThrottleKey(userID string) string {
+	return throttle.Key("user", userID)
+}
+
+// mailThrottleKey считает письма с кодами, отправленные по запросу пользователя:
+// каждая отправка — "неудача", чтобы адрес нельзя было засыпать письмами.
+func mailThrottleKey(userID string) string {
+	return throttle.Key("mail", userID)
+}
+
+// deviceCodeThrottleKey считает коды устройств, выданные на один IP, отдельно от неудачных входов.
+func deviceCodeThrottleKey(r *http.Request) string {
+	return throttle.Key("device", clientIP(r))
+}
+
+// throttled отвечает 429 с Retry-After, если по одному из ключей действует задержка.
+func (h *AuthHandler) throttled(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	if h.Limiter == nil {
+		return false
+	}
+
+	wait, err := h.Limiter.Check(r.Context(), keys...)
+	if err != nil {
+		log.Printf("[throttle] db error (Check): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return true
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "too many attempts", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// recordFailure учитывает неудачную попытку. Если она наложила задержку, сразу сообщает
+// о ней через Retry-After — вызывать до записи ответа.
+func (h *AuthHandler) recordFailure(w http.ResponseWriter, r *http.Request, keys ...string) {
+	if h.Limiter == nil {
+		return
+	}
+
+	wait, err := h.Limiter.Fail(r.Context(), keys...)
+	if err != nil {
+		log.Printf("[throttle] db error (Fail): %v", err)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+	}
+}
+
+func (h *AuthHandler) resetFailures(r *http.Request, keys ...string) {
+	if h.Limiter == nil {
+		return
+	}
+	if err := h.Limiter.Reset(r.Context(), keys...); err != nil {
+		log.Printf("[throttle] db error (Reset): %v", err)
+	}
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
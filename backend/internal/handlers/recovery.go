@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"passkeys/internal/middleware"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeBytes — 48 бит на код, в base32 это 10 символов.
+	recoveryCodeBytes = 6
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type recoveryCodesResponse struct {
+	Codes []string `json:"codes"`
+}
+
+type recoveryCodesStatusResponse struct {
+	Remaining int `json:"remaining"`
+}
+
+func (h *AuthHandler) RecoveryCodesStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	remaining, err := h.remainingRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, recoveryCodesStatusResponse{Remaining: remaining})
+}
+
+// RegenerateRecoveryCodes выдаёт новый набор кодов и делает недействительными все старые.
+// Коды показываются один раз, сервер хранит только их хэши.
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req reauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireReauth(w, r, user.ID, req) {
+		return
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			http.Error(w, "code generation failed", http.StatusInternalServerError)
+			return
+		}
+		codes = append(codes, code)
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "delete from recovery_codes where user_id=$1", user.ID); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(ctx,
+			"insert into recovery_codes (user_id, code_hash) values ($1, $2)",
+			user.ID, hashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			log.Printf("[RegenerateRecoveryCodes] db error (insert code): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, recoveryCodesResponse{Codes: codes})
+}
+
+// useRecoveryCode гасит код, если он принадлежит пользователю и ещё не использован.
+func (h *AuthHandler) useRecoveryCode(ctx context.Context, userID, code string) (bool, error) {
+	tag, err := h.DB.Exec(ctx,
+		"update recovery_codes set used_at=now() where user_id=$1 and code_hash=$2 and used_at is null",
+		userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (h *AuthHandler) remainingRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var remaining int
+	err := h.DB.QueryRow(ctx,
+		"select count(*) from recovery_codes where user_id=$1 and used_at is null", userID,
+	).Scan(&remaining)
+	return remaining, err
+}
+
+// generateRecoveryCode возвращает код вида "abcde-fghij".
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))
+	return encoded[:5] + "-" + encoded[5:], nil
+}
+
+// normalizeRecoveryCode прощает регистр, дефисы и пробелы при вводе кода.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
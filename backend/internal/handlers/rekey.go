@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+
+	"passkeys/internal/middleware"
+)
+
+// errVaultMismatch — присланные записи не совпадают с хранилищем: клиент что-то пропустил
+// или хранилище изменилось, пока он перешифровывал. Клиенту нужно перечитать данные и повторить.
+var errVaultMismatch = errors.New("vault changed, reload and retry")
+
+type rekeyAccount struct {
+	ID string `json:"id"`
+	// URL и Label не зашифрованы и при перешифровании не меняются.
+	accountRequest
+}
+
+type rekeyNote struct {
+	ID string `json:"id"`
+	noteRequest
+}
+
+// rekeyFolder — папка с именем, зашифрованным новым ключом.
+type rekeyFolder struct {
+	ID         string `json:"id"`
+	NameCipher string `json:"nameCipher"`
+	NameNonce  string `json:"nameNonce"`
+}
+
+// rekeyItem — запись, перешифрованная через /items: конверт целиком.
+type rekeyItem struct {
+	ID      string      `json:"id"`
+	Payload itemPayload `json:"payload"`
+}
+
+type rekeyRequest struct {
+	changePasswordRequest
+	// Accounts и Notes — записи в виде представлений /accounts и /notes, Items — любые записи.
+	Accounts []rekeyAccount `json:"accounts"`
+	Notes    []rekeyNote    `json:"notes"`
+	Items    []rekeyItem    `json:"items"`
+	Folders  []rekeyFolder  `json:"folders"`
+	// Закрытый ключ пары (экстренный доступ) зашифрован ключом хранилища — обязателен, если пара есть.
+	PrivateKeyCipher string `json:"privateKeyCipher,omitempty"`
+	PrivateKeyNonce  string `json:"privateKeyNonce,omitempty"`
+}
+
+// rekeyEntry — перешифрованная запись; itemType задан, если она пришла через представление.
+type rekeyEntry struct {
+	id       string
+	itemType string
+	payload  itemPayload
+}
+
+type rekeyFolderName struct {
+	id            string
+	cipher, nonce []byte
+}
+
+// RekeyVault меняет мастер-пароль вместе с перешифрованным хранилищем в одной транзакции.
+// Клиент присылает новые данные пароля и все записи, зашифрованные новым ключом; набор
+// записей должен в точности совпасть с хранилищем, иначе не меняется ничего. С новым
+// ключом хранилища (newVaultKeyCipher) растёт его поколение — так же старое хранилище
+// переходит на свежий ключ вместо ключа из пароля.
+func (h *AuthHandler) RekeyVault(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req rekeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	entries := make([]rekeyEntry, 0, len(req.Accounts)+len(req.Notes)+len(req.Items))
+	for _, item := range req.Accounts {
+		entries = append(entries, rekeyEntry{id: item.ID, itemType: itemTypeLogin, payload: item.item().Payload})
+	}
+	for _, item := range req.Notes {
+		if item.TitleCipher == "" || item.TextCipher == "" {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		entries = append(entries, rekeyEntry{id: item.ID, itemType: itemTypeNote, payload: item.item().Payload})
+	}
+	for _, item := range req.Items {
+		entries = append(entries, rekeyEntry{id: item.ID, payload: item.Payload})
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		err := itemRequest{Payload: entry.payload}.validate()
+		if err != nil || entry.id == "" || len(entry.payload) == 0 || seen[entry.id] {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		seen[entry.id] = true
+	}
+	entryIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entryIDs = append(entryIDs, entry.id)
+	}
+
+	folderIDs := make([]string, 0, len(req.Folders))
+	folderNames := make([]rekeyFolderName, 0, len(req.Folders))
+	for _, folder := range req.Folders {
+		nameCipher, nameNonce, err := decodeFolderName(folderRequest{NameCipher: folder.NameCipher, NameNonce: folder.NameNonce})
+		if err != nil || folder.ID == "" || seen[folder.ID] {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		seen[folder.ID] = true
+		folderIDs = append(folderIDs, folder.ID)
+		folderNames = append(folderNames, rekeyFolderName{id: folder.ID, cipher: nameCipher, nonce: nameNonce})
+	}
+
+	var privateKeyCipher, privateKeyNonce []byte
+	if req.PrivateKeyCipher != "" {
+		var errCipher, errNonce error
+		privateKeyCipher, errCipher = base64.StdEncoding.DecodeString(req.PrivateKeyCipher)
+		privateKeyNonce, errNonce = base64.StdEncoding.DecodeString(req.PrivateKeyNonce)
+		if errCipher != nil || errNonce != nil || len(privateKeyNonce) == 0 {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	h.changePassword(w, r, user.ID, req.changePasswordRequest, func(ctx context.Context, tx pgx.Tx) error {
+		// FOR UPDATE на пользователе конфликтует с блокировкой внешнего ключа при вставке записей:
+		// новые записи не появятся между проверкой полноты и коммитом, а уже начатые вставки
+		// дождутся и попадут в проверку.
+		if _, err := tx.Exec(ctx, "select 1 from users where id=$1 for update", user.ID); err != nil {
+			return err
+		}
+		if err := checkVaultCoverage(ctx, tx, "items", user.ID, entryIDs); err != nil {
+			return err
+		}
+		if err := checkVaultCoverage(ctx, tx, "folders", user.ID, folderIDs); err != nil {
+			return err
+		}
+		if err := rewriteItems(ctx, tx, user.ID, entries); err != nil {
+			return err
+		}
+		for _, folder := range folderNames {
+			if _, err := tx.Exec(ctx,
+				"update folders set name_cipher=$1, name_nonce=$2, updated_at=now() where id=$3 and user_id=$4",
+				folder.cipher, folder.nonce, folder.id, user.ID,
+			); err != nil {
+				return err
+			}
+		}
+
+		var hasKeyPair bool
+		if err := tx.QueryRow(ctx, "select private_key_cipher is not null from users where id=$1", user.ID).Scan(&hasKeyPair); err != nil {
+			return err
+		}
+		if hasKeyPair != (privateKeyCipher != nil) {
+			return errVaultMismatch
+		}
+		if hasKeyPair {
+			if _, err := tx.Exec(ctx,
+				"update users set private_key_cipher=$1, private_key_nonce=$2 where id=$3",
+				privateKeyCipher, privateKeyNonce, user.ID,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// checkVaultCoverage блокирует строки пользователя в table до конца транзакции и проверяет,
+// что ids содержит ровно их.
+func checkVaultCoverage(ctx context.Context, tx pgx.Tx, table, userID string, ids []string) error {
+	rows, err := tx.Query(ctx, "select id::text from "+table+" where user_id=$1 for update", userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	expected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		expected[id] = true
+	}
+	count := 0
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if !expected[id] {
+			return errVaultMismatch
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if count != len(ids) {
+		return errVaultMismatch
+	}
+	return nil
+}
+
+// rewriteItems заменяет конверты записей. Новый конверт должен содержать все поля записи:
+// иначе поля, которых клиент не знает (например, добавленные через /items к аккаунту),
+// остались бы зашифрованы старым ключом.
+func rewriteItems(ctx context.Context, tx pgx.Tx, userID string, entries []rekeyEntry) error {
+	batch := &pgx.Batch{}
+	for _, entry := range entries {
+		fields := make([]string, 0, len(entry.payload))
+		for name := range entry.payload {
+			fields = append(fields, name)
+		}
+		batch.Queue(`
+			update items set payload=$1, updated_at=now()
+			where id=$2 and user_id=$3 and ($4='' or type=$4) and payload - $5::text[] = '{}'::jsonb`,
+			entry.payload, entry.id, userID, entry.itemType, fields)
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+	for range entries {
+		commandTag, err := results.Exec()
+		if err != nil {
+			return err
+		}
+		if commandTag.RowsAffected() != 1 {
+			return errVaultMismatch
+		}
+	}
+	return results.Close()
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"passkeys/internal/auth"
+	"passkeys/internal/middleware"
+)
+
+// vaultTestEnv — пользователи с записями и папками в тестовой БД и маршрутизатор с проверкой JWT.
+type vaultTestEnv struct {
+	t      *testing.T
+	pool   *pgxpool.Pool
+	auth   *AuthHandler
+	router chi.Router
+}
+
+type vaultTestUser struct {
+	id       string
+	token    string
+	authHash []byte
+}
+
+func newVaultTestEnv(t *testing.T) *vaultTestEnv {
+	t.Helper()
+	pool := openTestDB(t)
+
+	signingKey, err := auth.GenerateSigningKey(auth.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &AuthHandler{
+		DB:                   pool,
+		Secret:               []byte("test secret"),
+		Keys:                 auth.NewKeySet(signingKey),
+		AccessTokenLifetime:  time.Minute,
+		RefreshTokenLifetime: time.Hour,
+		Hasher:               auth.NewArgon2idHasher(),
+	}
+	router := chi.NewRouter()
+	router.Use(middleware.AuthMiddleware(h.Keys, nil, nil))
+	router.Post("/auth/vault/rekey", h.RekeyVault)
+
+	return &vaultTestEnv{t: t, pool: pool, auth: h, router: router}
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// createUser заводит пользователя с неподтверждённым адресом: уведомления ему не отправляются.
+func (e *vaultTestEnv) createUser(email string) vaultTestUser {
+	e.t.Helper()
+	authHash := randomBytes(e.t, authHashLength)
+	hash, err := e.auth.Hasher.Hash(authHash)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	var userID string
+	if err := e.pool.QueryRow(context.Background(), `
+		insert into users (email, password_hash, kdf_salt, auth_scheme) values ($1, $2, $3, $4)
+		returning id`,
+		email, hash, randomBytes(e.t, 16), authSchemeAuthHash,
+	).Scan(&userID); err != nil {
+		e.t.Fatal(err)
+	}
+	token, err := auth.CreateToken(e.auth.Keys, userID, email, "")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return vaultTestUser{id: userID, token: token, authHash: authHash}
+}
+
+func testField(value string) encryptedField {
+	return encryptedField{
+		Cipher: base64.StdEncoding.EncodeToString([]byte(value)),
+		Nonce:  base64.StdEncoding.EncodeToString([]byte("nonce-" + value)),
+	}
+}
+
+// insertItem добавляет запись с полями fields, зашифрованными "старым ключом".
+func (e *vaultTestEnv) insertItem(userID, itemType string, trashed bool, fields ...string) string {
+	e.t.Helper()
+	payload := itemPayload{}
+	for _, name := range fields {
+		payload[name] = testField("old " + name)
+	}
+	var id string
+	if err := e.pool.QueryRow(context.Background(), `
+		insert into items (user_id, type, payload, deleted_at) values ($1, $2, $3, case when $4 then now() end)
+		returning id`,
+		userID, itemType, payload, trashed,
+	).Scan(&id); err != nil {
+		e.t.Fatal(err)
+	}
+	return id
+}
+
+func (e *vaultTestEnv) insertFolder(userID string, parentID *string) string {
+	e.t.Helper()
+	var id string
+	if err := e.pool.QueryRow(context.Background(),
+		"insert into folders (user_id, parent_id, name_cipher, name_nonce) values ($1, $2, 'old name', 'old nonce') returning id",
+		userID, parentID,
+	).Scan(&id); err != nil {
+		e.t.Fatal(err)
+	}
+	return id
+}
+
+func (e *vaultTestEnv) do(user vaultTestUser, method, path string, body any) *httptest.ResponseRecorder {
+	e.t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+user.token)
+	rec := httptest.NewRecorder()
+	e.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// itemPayloadOf возвращает текущий конверт записи.
+func (e *vaultTestEnv) itemPayloadOf(id string) itemPayload {
+	e.t.Helper()
+	var payload itemPayload
+	if err := e.pool.QueryRow(context.Background(), "select payload from items where id=$1", id).Scan(&payload); err != nil {
+		e.t.Fatal(err)
+	}
+	return payload
+}
+
+// rekeyTestVault — хранилище пользователя: аккаунт, заметка, карта в корзине и две папки.
+type rekeyTestVault struct {
+	user                   vaultTestUser
+	account, note, card    string
+	folder, subfolder      string
+	otherItem, otherFolder string
+	passwordHash           string
+}
+
+func newRekeyTestVault(e *vaultTestEnv) rekeyTestVault {
+	e.t.Helper()
+	v := rekeyTestVault{user: e.createUser("rekey@example.com")}
+	v.account = e.insertItem(v.user.id, itemTypeLogin, false, "username", "password")
+	v.note = e.insertItem(v.user.id, itemTypeNote, false, "title", "text")
+	v.card = e.insertItem(v.user.id, itemTypeCard, true, "number", "cvv")
+	v.folder = e.insertFolder(v.user.id, nil)
+	v.subfolder = e.insertFolder(v.user.id, &v.folder)
+
+	other := e.createUser("other@example.com")
+	v.otherItem = e.insertItem(other.id, itemTypeLogin, false, "username", "password")
+	v.otherFolder = e.insertFolder(other.id, nil)
+
+	if err := e.pool.QueryRow(context.Background(), "select password_hash from users where id=$1", v.user.id).Scan(&v.passwordHash); err != nil {
+		e.t.Fatal(err)
+	}
+	return v
+}
+
+// request собирает полный и корректный запрос перешифрования хранилища v.
+func (v rekeyTestVault) request(t *testing.T) rekeyRequest {
+	username, password := testField("new username"), testField("new password")
+	title, text := testField("new title"), testField("new text")
+	return rekeyRequest{
+		changePasswordRequest: changePasswordRequest{
+			CurrentAuthHash: base64.StdEncoding.EncodeToString(v.user.authHash),
+			NewAuthHash:     base64.StdEncoding.EncodeToString(randomBytes(t, authHashLength)),
+			NewKdfSalt:      base64.StdEncoding.EncodeToString(randomBytes(t, 16)),
+		},
+		Accounts: []rekeyAccount{{ID: v.account, accountRequest: accountRequest{
+			URL: "https://example.com", UsernameCipher: username.Cipher, UsernameNonce: username.Nonce,
+			PasswordCipher: password.Cipher, PasswordNonce: password.Nonce,
+		}}},
+		Notes: []rekeyNote{{ID: v.note, noteRequest: noteRequest{
+			TitleCipher: title.Cipher, TitleNonce: title.Nonce, TextCipher: text.Cipher, TextNonce: text.Nonce,
+		}}},
+		Items: []rekeyItem{{ID: v.card, Payload: itemPayload{"number": testField("new number"), "cvv": testField("new cvv")}}},
+		Folders: []rekeyFolder{
+			{ID: v.folder, NameCipher: base64.StdEncoding.EncodeToString([]byte("new name")), NameNonce: base64.StdEncoding.EncodeToString([]byte("new nonce"))},
+			{ID: v.subfolder, NameCipher: base64.StdEncoding.EncodeToString([]byte("new name")), NameNonce: base64.StdEncoding.EncodeToString([]byte("new nonce"))},
+		},
+	}
+}
+
+func TestRekeyVaultRewritesEveryRecord(t *testing.T) {
+	env := newVaultTestEnv(t)
+	v := newRekeyTestVault(env)
+
+	rec := env.do(v.user, http.MethodPost, "/auth/vault/rekey", v.request(t))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rekey: %d %s", rec.Code, rec.Body)
+	}
+
+	if got := env.itemPayloadOf(v.account)["password"]; got != testField("new password") {
+		t.Errorf("account password = %+v", got)
+	}
+	if got := env.itemPayloadOf(v.note)["text"]; got != testField("new text") {
+		t.Errorf("note text = %+v", got)
+	}
+	// Записи в корзине тоже перешифровываются: их можно восстановить.
+	if got := env.itemPayloadOf(v.card)["cvv"]; got != testField("new cvv") {
+		t.Errorf("trashed card cvv = %+v", got)
+	}
+	var oldNames int
+	if err := env.pool.QueryRow(context.Background(),
+		"select count(*) from folders where user_id=$1 and name_cipher<>'new name'", v.user.id,
+	).Scan(&oldNames); err != nil {
+		t.Fatal(err)
+	}
+	if oldNames != 0 {
+		t.Errorf("%d folders keep the old name", oldNames)
+	}
+	if got := env.itemPayloadOf(v.otherItem)["password"]; got != testField("old password") {
+		t.Errorf("other user's item changed: %+v", got)
+	}
+}
+
+func TestRekeyVaultRejectsMismatch(t *testing.T) {
+	env := newVaultTestEnv(t)
+	v := newRekeyTestVault(env)
+
+	tests := []struct {
+		name   string
+		modify func(req *rekeyRequest)
+		want   int
+	}{
+		{"missing item", func(req *rekeyRequest) { req.Notes = nil }, http.StatusConflict},
+		{"missing trashed item", func(req *rekeyRequest) { req.Items = nil }, http.StatusConflict},
+		{"unknown item", func(req *rekeyRequest) {
+			req.Items = append(req.Items, rekeyItem{ID: "00000000-0000-0000-0000-000000000000", Payload: itemPayload{"x": testField("x")}})
+		}, http.StatusConflict},
+		{"another user's item", func(req *rekeyRequest) {
+			req.Items = append(req.Items, rekeyItem{ID: v.otherItem, Payload: itemPayload{"username": testField("x"), "password": testField("x")}})
+		}, http.StatusConflict},
+		{"another user's item instead of own", func(req *rekeyRequest) {
+			req.Accounts[0].ID = v.otherItem
+		}, http.StatusConflict},
+		{"duplicate item", func(req *rekeyRequest) {
+			req.Items = append(req.Items, rekeyItem{ID: v.note, Payload: itemPayload{"title": testField("x"), "text": testField("x")}})
+		}, http.StatusBadRequest},
+		{"item as folder", func(req *rekeyRequest) {
+			req.Folders = append(req.Folders, rekeyFolder{ID: v.note, NameCipher: "eA==", NameNonce: "eA=="})
+		}, http.StatusBadRequest},
+		// Через представление /notes нельзя перешифровать аккаунт.
+		{"wrong view", func(req *rekeyRequest) {
+			req.Notes = append(req.Notes, rekeyNote{ID: v.account, noteRequest: req.Notes[0].noteRequest})
+			req.Accounts = nil
+		}, http.StatusConflict},
+		// Конверт без поля cvv оставил бы его зашифрованным старым ключом.
+		{"payload drops a field", func(req *rekeyRequest) {
+			req.Items[0].Payload = itemPayload{"number": testField("new number")}
+		}, http.StatusConflict},
+		{"missing folder", func(req *rekeyRequest) { req.Folders = req.Folders[:1] }, http.StatusConflict},
+		{"another user's folder", func(req *rekeyRequest) {
+			req.Folders = append(req.Folders, rekeyFolder{ID: v.otherFolder, NameCipher: "eA==", NameNonce: "eA=="})
+		}, http.StatusConflict},
+		{"duplicate folder", func(req *rekeyRequest) { req.Folders = append(req.Folders, req.Folders[0]) }, http.StatusBadRequest},
+		{"empty item id", func(req *rekeyRequest) { req.Items[0].ID = "" }, http.StatusBadRequest},
+		{"wrong password", func(req *rekeyRequest) {
+			req.CurrentAuthHash = base64.StdEncoding.EncodeToString(randomBytes(t, authHashLength))
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(parent *testing.T) { env.t = parent }(env.t)
+			env.t = t
+
+			req := v.request(t)
+			tt.modify(&req)
+			rec := env.do(v.user, http.MethodPost, "/auth/vault/rekey", req)
+			if rec.Code != tt.want {
+				t.Fatalf("rekey: %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+
+			// Ничего не меняется: ни пароль, ни записи, ни записи другого пользователя.
+			var passwordHash string
+			if err := env.pool.QueryRow(context.Background(), "select password_hash from users where id=$1", v.user.id).Scan(&passwordHash); err != nil {
+				t.Fatal(err)
+			}
+			if passwordHash != v.passwordHash {
+				t.Error("password changed")
+			}
+			for _, id := range []string{v.account, v.note, v.card, v.otherItem} {
+				for name, field := range env.itemPayloadOf(id) {
+					if field != testField("old "+name) {
+						t.Errorf("item %s field %s rewritten", id, name)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// События в security_events.
+const (
+	eventRefreshTokenReuse        = "refresh_token_reuse"
+	eventAccountDeletionRequested = "account_deletion_requested"
+	eventAccountDeletionCancelled = "account_deletion_cancelled"
+	eventEmailChanged             = "email_changed"
+	eventEmergencyAccessRequested = "emergency_access_requested"
+	eventEmergencyVaultAccessed   = "emergency_vault_accessed"
+	eventAccountDisabled          = "account_disabled"
+	eventAccountEnabled           = "account_enabled"
+	eventForcedLogout             = "forced_logout"
+)
+
+// logSecurityEvent пишет событие в журнал и в лог процесса. Ошибка записи не прерывает запрос.
+func (h *AuthHandler) logSecurityEvent(ctx context.Context, r *http.Request, userID, event, details string) {
+	log.Printf("[security] %s user=%s ip=%s %s", event, userID, clientIP(r), details)
+
+	if _, err := h.DB.Exec(ctx,
+		"insert into security_events (user_id, event, ip, user_agent, details) values ($1, $2, $3, $4, $5)",
+		userID, event, clientIP(r), truncate(r.UserAgent(), maxUserAgentLength), details,
+	); err != nil {
+		log.Printf("[security] db error (insert event): %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"passkeys/internal/middleware"
+)
+
+// deviceNameHeader — необязательное имя устройства, которое клиент передаёт при входе.
+const deviceNameHeader = "X-Device-Name"
+
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 512
+)
+
+type sessionResponse struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"deviceName"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	Current    bool      `json:"current"`
+}
+
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(), `
+		select id, device_name, user_agent, ip, created_at, last_used_at
+		from sessions where user_id=$1 order by last_used_at desc`, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sessions := make([]sessionResponse, 0)
+	for rows.Next() {
+		var item sessionResponse
+		if err := rows.Scan(
+			&item.ID,
+			&item.DeviceName,
+			&item.UserAgent,
+			&item.IP,
+			&item.CreatedAt,
+			&item.LastUsedAt,
+		); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		item.Current = item.ID == user.SessionID
+		sessions = append(sessions, item)
+	}
+
+	respondJSON(w, sessions)
+}
+
+// RevokeSession завершает одну сессию: её refresh-токены удаляются каскадом.
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := chi.URLParam(r, "id")
+	if sessionID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	ended, err := h.endSessions(r.Context(), "id::text=$1 and user_id=$2", sessionID, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if ended == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions — "выйти на всех остальных устройствах". Токен без сессии
+// (выданный до её появления) ни с одной не совпадает, и завершаются все.
+func (h *AuthHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := h.endSessions(r.Context(), "user_id=$1 and id::text<>$2", user.ID, user.SessionID); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type logoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Logout завершает текущую сессию: её refresh-токены удаляются, а access-токен
+// перестаёт приниматься сразу, не дожидаясь истечения. Для токенов без сессии
+// сессию находим по переданному refresh-токену.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req logoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sessionID := user.SessionID
+	if sessionID == "" && req.RefreshToken != "" {
+		err := h.DB.QueryRow(r.Context(),
+			"select session_id from refresh_tokens where token_hash=$1 and user_id=$2",
+			hashToken(req.RefreshToken), user.ID,
+		).Scan(&sessionID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if sessionID != "" {
+		if _, err := h.endSessions(r.Context(), "id::text=$1 and user_id=$2", sessionID, user.ID); err != nil {
+			log.Printf("[Logout] db error (end session): %v", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// endSessions удаляет сессии по условию (refresh-токены уходят каскадом) и отзывает
+// их access-токены в кэше этой реплики. Возвращает число завершённых сессий.
+func (h *AuthHandler) endSessions(ctx context.Context, where string, args ...any) (int, error) {
+	rows, err := h.DB.Query(ctx, "delete from sessions where "+where+" returning id::text", args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if h.Sessions != nil {
+		h.Sessions.Revoke(ids...)
+	}
+	return len(ids), nil
+}
+
+func (h *AuthHandler) startSession(ctx context.Context, r *http.Request, userID string) (string, error) {
+	var sessionID string
+	err := h.DB.QueryRow(ctx,
+		"insert into sessions (user_id, device_name, user_agent, ip) values ($1, $2, $3, $4) returning id",
+		userID,
+		truncate(r.Header.Get(deviceNameHeader), maxDeviceNameLength),
+		truncate(r.UserAgent(), maxUserAgentLength),
+		clientIP(r),
+	).Scan(&sessionID)
+	return sessionID, err
+}
+
+// touchSession обновляет время и адрес последнего использования при ротации refresh-токена.
+func (h *AuthHandler) touchSession(ctx context.Context, r *http.Request, sessionID string) error {
+	_, err := h.DB.Exec(ctx,
+		"update sessions set last_used_at=now(), user_agent=$1, ip=$2 where id=$3",
+		truncate(r.UserAgent(), maxUserAgentLength), clientIP(r), sessionID,
+	)
+	return err
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit])
+}
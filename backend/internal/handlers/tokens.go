@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"passkeys/internal/auth"
+	"passkeys/internal/middleware"
+)
+
+const (
+	maxTokenNameLength  = 100
+	maxTokensPerUser    = 50
+	maxPersonalTokenAge = 366 * 24 * time.Hour
+)
+
+type createTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt необязателен: без него токен действует до отзыва.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	reauthRequest
+}
+
+type tokenResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	// Token — сам токен, только в ответе на создание.
+	Token string `json:"token,omitempty"`
+}
+
+func (h *AuthHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(), `
+		select id, name, scopes, expires_at, last_used_at, created_at
+		from personal_access_tokens where user_id=$1 order by created_at desc`, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := make([]tokenResponse, 0)
+	for rows.Next() {
+		var item tokenResponse
+		if err := rows.Scan(
+			&item.ID,
+			&item.Name,
+			&item.Scopes,
+			&item.ExpiresAt,
+			&item.LastUsedAt,
+			&item.CreatedAt,
+		); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		tokens = append(tokens, item)
+	}
+
+	respondJSON(w, tokens)
+}
+
+// CreateToken выпускает персональный токен доступа. Токен показывается один раз,
+// сервер хранит только его хэш.
+func (h *AuthHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req createTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > maxTokenNameLength {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "scopes required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			http.Error(w, "unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && (req.ExpiresAt.Before(time.Now()) || time.Until(*req.ExpiresAt) > maxPersonalTokenAge) {
+		http.Error(w, "invalid expiry", http.StatusBadRequest)
+		return
+	}
+	if !h.requireReauth(w, r, user.ID, req.reauthRequest) {
+		return
+	}
+
+	ctx := r.Context()
+	var count int
+	if err := h.DB.QueryRow(ctx, "select count(*) from personal_access_tokens where user_id=$1", user.ID).Scan(&count); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxTokensPerUser {
+		http.Error(w, "too many tokens", http.StatusConflict)
+		return
+	}
+
+	token, err := auth.GeneratePersonalToken()
+	if err != nil {
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	item := tokenResponse{Name: name, Scopes: req.Scopes, ExpiresAt: req.ExpiresAt, Token: token}
+	if err := h.DB.QueryRow(ctx, `
+		insert into personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+		values ($1, $2, $3, $4, $5) returning id, created_at`,
+		user.ID, name, auth.HashPersonalToken(token), req.Scopes, req.ExpiresAt,
+	).Scan(&item.ID, &item.CreatedAt); err != nil {
+		log.Printf("[CreateToken] db error (insert): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, item)
+}
+
+func (h *AuthHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokenID := chi.URLParam(r, "id")
+	if tokenID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	commandTag, err := h.DB.Exec(r.Context(), "delete from personal_access_tokens where id::text=$1 and user_id=$2", tokenID, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
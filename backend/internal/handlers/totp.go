@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"passkeys/internal/auth"
+	"passkeys/internal/middleware"
+	"passkeys/internal/totp"
+)
+
+const totpIssuer = "Passkeys"
+
+type totpStatusResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+type totpSetupResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+type totpConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+func (h *AuthHandler) TOTPStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var enabled bool
+	if err := h.DB.QueryRow(r.Context(),
+		"select exists(select 1 from user_totp where user_id=$1 and confirmed_at is not null)", user.ID,
+	).Scan(&enabled); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, totpStatusResponse{Enabled: enabled})
+}
+
+// TOTPSetup генерирует новый секрет. До подтверждения кодом он не участвует во входе,
+// а повторный вызов заменяет неподтверждённый секрет.
+func (h *AuthHandler) TOTPSetup(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "secret generation failed", http.StatusInternalServerError)
+		return
+	}
+	cipher, nonce, err := auth.Seal(h.MFAKey, secret)
+	if err != nil {
+		http.Error(w, "encryption failed", http.StatusInternalServerError)
+		return
+	}
+
+	tag, err := h.DB.Exec(r.Context(), `
+		insert into user_totp (user_id, secret_cipher, secret_nonce)
+		values ($1, $2, $3)
+		on conflict (user_id) do update
+		set secret_cipher=excluded.secret_cipher, secret_nonce=excluded.secret_nonce, last_used_step=null, created_at=now()
+		where user_totp.confirmed_at is null`,
+		user.ID, cipher, nonce,
+	)
+	if err != nil {
+		log.Printf("[TOTPSetup] db error (upsert): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "totp already enabled", http.StatusConflict)
+		return
+	}
+
+	respondJSON(w, totpSetupResponse{
+		Secret:     totp.EncodeSecret(secret),
+		OtpauthURI: totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// TOTPConfirm включает TOTP после того, как пользователь ввёл код из приложения.
+func (h *AuthHandler) TOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req totpConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	secret, _, err := h.loadTOTPSecret(r.Context(), user.ID, false)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "totp setup required", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	step, valid := totp.Validate(secret, req.Code, time.Now())
+	if !valid {
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	tag, err := h.DB.Exec(r.Context(),
+		"update user_totp set confirmed_at=now(), last_used_step=$1 where user_id=$2 and confirmed_at is null",
+		step, user.ID,
+	)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "totp already enabled", http.StatusConflict)
+		return
+	}
+
+	respondJSON(w, totpStatusResponse{Enabled: true})
+}
+
+// TOTPDisable выключает TOTP; требует мастер-пароль, а не код, чтобы работать и при потерянном телефоне.
+func (h *AuthHandler) TOTPDisable(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req reauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireReauth(w, r, user.ID, req) {
+		return
+	}
+
+	if _, err := h.DB.Exec(r.Context(), "delete from user_totp where user_id=$1", user.ID); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, totpStatusResponse{Enabled: false})
+}
+
+// verifyTOTP проверяет код подтверждённого TOTP и помечает шаг использованным,
+// так что перехваченный код нельзя предъявить повторно.
+func (h *AuthHandler) verifyTOTP(ctx context.Context, userID, code string) (bool, error) {
+	secret, lastUsedStep, err := h.loadTOTPSecret(ctx, userID, true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	step, valid := totp.ValidateAfter(secret, code, time.Now(), lastUsedStep)
+	if !valid {
+		return false, nil
+	}
+
+	// Условие повторяет проверку шага: параллельный вход тем же кодом пройдёт только один.
+	tag, err := h.DB.Exec(ctx,
+		"update user_totp set last_used_step=$1 where user_id=$2 and (last_used_step is null or last_used_step<$1)",
+		step, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// loadTOTPSecret возвращает расшифрованный секрет и последний использованный шаг.
+func (h *AuthHandler) loadTOTPSecret(ctx context.Context, userID string, confirmed bool) ([]byte, *int64, error) {
+	var cipher, nonce []byte
+	var lastUsedStep *int64
+	if err := h.DB.QueryRow(ctx,
+		"select secret_cipher, secret_nonce, last_used_step from user_totp where user_id=$1 and (confirmed_at is not null)=$2",
+		userID, confirmed,
+	).Scan(&cipher, &nonce, &lastUsedStep); err != nil {
+		return nil, nil, err
+	}
+	secret, err := auth.Open(h.MFAKey, cipher, nonce)
+	return secret, lastUsedStep, err
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"passkeys/internal/middleware"
+)
+
+// Корзина: Delete в /items, /accounts и /notes только помечает запись удалённой.
+// Из корзины запись можно восстановить или удалить окончательно; записи старше
+// TrashRetention удаляет PurgeTrash.
+
+func (h *ItemHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemType := r.URL.Query().Get("type")
+	if itemType != "" && !validItemType(itemType) {
+		http.Error(w, "unknown item type", http.StatusBadRequest)
+		return
+	}
+
+	items, err := listItems(r.Context(), h.DB, user.ID, itemFilter{Type: itemType, Trash: true})
+	if err != nil {
+		log.Printf("[Trash] db error (list): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, items)
+}
+
+// RestoreItem возвращает запись из корзины. Если её папку тем временем удалили,
+// запись окажется в корне.
+func (h *ItemHandler) RestoreItem(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID := chi.URLParam(r, "id")
+	if itemID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	item, err := scanItem(h.DB.QueryRow(r.Context(), `
+		update items set deleted_at=null
+		where id=$1 and user_id=$2 and deleted_at is not null
+		returning `+itemColumns,
+		itemID, user.ID,
+	))
+	if err != nil {
+		itemError(w, "restore", err)
+		return
+	}
+
+	respondJSON(w, item)
+}
+
+// PurgeItem окончательно удаляет запись из корзины.
+func (h *ItemHandler) PurgeItem(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID := chi.URLParam(r, "id")
+	if itemID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	commandTag, err := h.DB.Exec(r.Context(),
+		"delete from items where id=$1 and user_id=$2 and deleted_at is not null", itemID, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EmptyTrash окончательно удаляет все записи из корзины пользователя.
+func (h *ItemHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := h.DB.Exec(r.Context(), "delete from items where user_id=$1 and deleted_at is not null", user.ID); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeTrash окончательно удаляет записи, пролежавшие в корзине дольше TrashRetention.
+func (h *ItemHandler) PurgeTrash(ctx context.Context) error {
+	commandTag, err := h.DB.Exec(ctx,
+		"delete from items where deleted_at<=now()-make_interval(secs => $1)", h.TrashRetention.Seconds())
+	if err != nil {
+		return err
+	}
+	if n := commandTag.RowsAffected(); n > 0 {
+		log.Printf("[PurgeTrash] deleted %d items", n)
+	}
+	return nil
+}
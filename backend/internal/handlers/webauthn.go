@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"passkeys/internal/auth"
+	"passkeys/internal/middleware"
+	"passkeys/internal/webauthn"
+)
+
+// Назначения challenge в webauthn_challenges.
+const (
+	challengeRegister = "register"
+	challengeMFA      = "mfa"
+	challengePasskey  = "passkey"
+)
+
+const challengeLifetime = 5 * time.Minute
+
+type webauthnRegisterBeginRequest struct {
+	// Discoverable требует от аутентификатора хранить ключ (passkey), чтобы им можно было входить без пароля.
+	Discoverable bool `json:"discoverable"`
+}
+
+type webauthnRegisterFinishRequest struct {
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+type webauthnMFABeginRequest struct {
+	MFAToken string `json:"mfaToken"`
+}
+
+type passkeyFinishRequest struct {
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+type webauthnCredentialResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// WebAuthnRegisterBegin выдаёт параметры navigator.credentials.create() для нового ключа.
+func (h *AuthHandler) WebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Тело необязательно: пустой запрос регистрирует обычный ключ второго фактора.
+	var req webauthnRegisterBeginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	residentKey := "preferred"
+	if req.Discoverable {
+		residentKey = "required"
+	}
+
+	ctx := r.Context()
+	exclude, err := h.credentialDescriptors(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := h.storeChallenge(ctx, &user.ID, challengeRegister)
+	if err != nil {
+		log.Printf("[WebAuthnRegisterBegin] db error (storeChallenge): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	// user handle — id пользователя: по нему discoverable-ключ находит владельца.
+	respondJSON(w, h.WebAuthn.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          []byte(user.ID),
+		Name:        user.Email,
+		DisplayName: user.Email,
+	}, exclude, residentKey))
+}
+
+func (h *AuthHandler) WebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req webauthnRegisterFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	challenge, err := webauthn.Challenge(req.Credential.Response.ClientDataJSON)
+	if err != nil {
+		http.Error(w, "invalid credential", http.StatusBadRequest)
+		return
+	}
+	challengeUserID, err := h.consumeChallenge(ctx, challenge, challengeRegister)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && (challengeUserID == nil || *challengeUserID != user.ID)) {
+		http.Error(w, "challenge expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := h.WebAuthn.VerifyRegistration(req.Credential, challenge, false)
+	if err != nil {
+		log.Printf("[WebAuthnRegisterFinish] verification failed: %v", err)
+		http.Error(w, "invalid credential", http.StatusBadRequest)
+		return
+	}
+
+	transports := req.Credential.Response.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	var response webauthnCredentialResponse
+	err = h.DB.QueryRow(ctx, `
+		insert into webauthn_credentials (user_id, credential_id, public_key, sign_count, transports, name)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (credential_id) do nothing
+		returning id, name, created_at, last_used_at`,
+		user.ID, credential.ID, credential.PublicKey, int64(credential.SignCount), transports, req.Name,
+	).Scan(&response.ID, &response.Name, &response.CreatedAt, &response.LastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "credential already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[WebAuthnRegisterFinish] db error (insert credential): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, response)
+}
+
+func (h *AuthHandler) WebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(), `
+		select id, name, created_at, last_used_at
+		from webauthn_credentials where user_id=$1 order by created_at`, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	credentials := make([]webauthnCredentialResponse, 0)
+	for rows.Next() {
+		var item webauthnCredentialResponse
+		if err := rows.Scan(&item.ID, &item.Name, &item.CreatedAt, &item.LastUsedAt); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		credentials = append(credentials, item)
+	}
+
+	respondJSON(w, credentials)
+}
+
+// WebAuthnDeleteCredential удаляет ключ; как и отключение TOTP, требует мастер-пароль.
+func (h *AuthHandler) WebAuthnDeleteCredential(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentialID := chi.URLParam(r, "id")
+	if credentialID == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+
+	var req reauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireReauth(w, r, user.ID, req) {
+		return
+	}
+
+	commandTag, err := h.DB.Exec(r.Context(), "delete from webauthn_credentials where id=$1 and user_id=$2", credentialID, user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if commandTag.RowsAffected() == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebAuthnMFABegin выдаёт параметры navigator.credentials.get() для второго шага входа.
+func (h *AuthHandler) WebAuthnMFABegin(w http.ResponseWriter, r *http.Request) {
+	var req webauthnMFABeginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := auth.ParseMFAToken(h.Keys, req.MFAToken)
+	if err != nil {
+		http.Error(w, "invalid mfa token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	allow, err := h.credentialDescriptors(ctx, claims.UserID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if len(allow) == 0 {
+		http.Error(w, "no security keys registered", http.StatusNotFound)
+		return
+	}
+
+	challenge, err := h.storeChallenge(ctx, &claims.UserID, challengeMFA)
+	if err != nil {
+		log.Printf("[WebAuthnMFABegin] db error (storeChallenge): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, h.WebAuthn.RequestOptions(challenge, allow, "discouraged"))
+}
+
+// PasskeyBegin выдаёт параметры get() без allowCredentials: браузер сам предложит
+// сохранённые passkeys для этого rpId.
+func (h *AuthHandler) PasskeyBegin(w http.ResponseWriter, r *http.Request) {
+	// Эндпоинт открытый: заблокированный по IP перебор не должен плодить challenge в БД.
+	if h.throttled(w, r, ipThrottleKey(r)) {
+		return
+	}
+
+	challenge, err := h.storeChallenge(r.Context(), nil, challengePasskey)
+	if err != nil {
+		log.Printf("[PasskeyBegin] db error (storeChallenge): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, h.WebAuthn.RequestOptions(challenge, nil, "required"))
+}
+
+// PasskeyFinish входит по discoverable-ключу без мастер-пароля. Пользователь определяется
+// по user handle, который должен совпасть с владельцем ключа. Второй фактор не запрашивается:
+// passkey с проверкой пользователя (UV) сам по себе двухфакторный.
+func (h *AuthHandler) PasskeyFinish(w http.ResponseWriter, r *http.Request) {
+	var req passkeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	userHandle := string(req.Credential.Response.UserHandle)
+	if userHandle == "" {
+		http.Error(w, "user handle required", http.StatusBadRequest)
+		return
+	}
+
+	ipKey := ipThrottleKey(r)
+	if h.throttled(w, r, ipKey) {
+		return
+	}
+
+	ctx := r.Context()
+	ownerID, ok, err := h.verifyWebAuthnAssertion(ctx, req.Credential, challengePasskey, true)
+	if err != nil {
+		log.Printf("[PasskeyFinish] db error (verifyWebAuthnAssertion): %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || ownerID != userHandle {
+		h.recordFailure(w, r, ipKey)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.findUserByID(ctx, ownerID)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	h.issueSession(w, r, user)
+}
+
+// verifyWebAuthnAssertion проверяет ответ get() на challenge, выданный для purpose,
+// и сдвигает счётчик подписей ключа. Возвращает id владельца ключа.
+func (h *AuthHandler) verifyWebAuthnAssertion(ctx context.Context, resp webauthn.AssertionResponse, purpose string, requireUV bool) (string, bool, error) {
+	challenge, err := webauthn.Challenge(resp.Response.ClientDataJSON)
+	if err != nil {
+		return "", false, nil
+	}
+	challengeUserID, err := h.consumeChallenge(ctx, challenge, purpose)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	var credentialID, userID string
+	var publicKey []byte
+	var signCount int64
+	err = h.DB.QueryRow(ctx,
+		"select id, user_id, public_key, sign_count from webauthn_credentials where credential_id=$1",
+		[]byte(resp.RawID),
+	).Scan(&credentialID, &userID, &publicKey, &signCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if challengeUserID != nil && *challengeUserID != userID {
+		return "", false, nil
+	}
+
+	newSignCount, err := h.WebAuthn.VerifyAssertion(resp, challenge, publicKey, uint32(signCount), requireUV)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		log.Printf("[WebAuthn] sign count regression for credential %s (user %s): possible cloned authenticator", credentialID, userID)
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, nil
+	}
+
+	// Условие на старый счётчик защищает от гонки двух одновременных входов одним ответом.
+	tag, err := h.DB.Exec(ctx,
+		"update webauthn_credentials set sign_count=$1, last_used_at=now() where id=$2 and sign_count=$3",
+		int64(newSignCount), credentialID, signCount,
+	)
+	if err != nil {
+		return "", false, err
+	}
+	return userID, tag.RowsAffected() == 1, nil
+}
+
+func (h *AuthHandler) hasWebAuthnCredentials(ctx context.Context, userID string) (bool, error) {
+	var exists bool
+	err := h.DB.QueryRow(ctx, "select exists(select 1 from webauthn_credentials where user_id=$1)", userID).Scan(&exists)
+	return exists, err
+}
+
+func (h *AuthHandler) credentialDescriptors(ctx context.Context, userID string) ([]webauthn.CredentialDescriptor, error) {
+	rows, err := h.DB.Query(ctx, "select credential_id, transports from webauthn_credentials where user_id=$1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	descriptors := make([]webauthn.CredentialDescriptor, 0)
+	for rows.Next() {
+		var descriptor webauthn.CredentialDescriptor
+		var id []byte
+		if err := rows.Scan(&id, &descriptor.Transports); err != nil {
+			return nil, err
+		}
+		descriptor.Type = "public-key"
+		descriptor.ID = id
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors, rows.Err()
+}
+
+// storeChallenge сохраняет новый challenge; заодно вычищает просроченные.
+func (h *AuthHandler) storeChallenge(ctx context.Context, userID *string, purpose string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	_, _ = h.DB.Exec(ctx, "delete from webauthn_challenges where expires_at<now()")
+
+	if _, err := h.DB.Exec(ctx,
+		"insert into webauthn_challenges (challenge, user_id, purpose, expires_at) values ($1, $2, $3, $4)",
+		challenge, userID, purpose, time.Now().Add(challengeLifetime),
+	); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// consumeChallenge одноразово забирает challenge; user_id может быть nil у challenge без пользователя.
+func (h *AuthHandler) consumeChallenge(ctx context.Context, challenge []byte, purpose string) (*string, error) {
+	var userID *string
+	err := h.DB.QueryRow(ctx,
+		"delete from webauthn_challenges where challenge=$1 and purpose=$2 and expires_at>now() returning user_id",
+		challenge, purpose,
+	).Scan(&userID)
+	return userID, err
+}
@@ -0,0 +1,115 @@
+// Package mail доставляет письма пользователям: коды подтверждения и уведомления безопасности.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRecipient = errors.New("invalid recipient address")
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Mailer — способ доставки писем; реализация выбирается конфигурацией (MAIL_DRIVER).
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer ничего не отправляет и пишет в лог процесса только адресата и тему: в тексте
+// бывают коды подтверждения, а логи читают не только владельцы аккаунтов. Чтобы видеть
+// письма целиком при разработке, есть FileMailer.
+type LogMailer struct{}
+
+func (LogMailer) Send(_ context.Context, msg Message) error {
+	log.Printf("[mail] to=%s subject=%q body=<%d bytes, not logged>", msg.To, msg.Subject, len(msg.Text))
+	return nil
+}
+
+// FileMailer складывает письма файлами .eml в Dir: их можно открыть почтовым клиентом
+// или разобрать в тестах без SMTP-сервера.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m FileMailer) Send(_ context.Context, msg Message) error {
+	data, err := msg.build(m.From, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
+
+// build собирает письмо по RFC 5322. Адрес получателя проверяется, чтобы через него
+// нельзя было дописать заголовки.
+func (msg Message) build(from string, now time.Time) ([]byte, error) {
+	to, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, ErrInvalidRecipient
+	}
+	sender, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", sender.String())
+	writeHeader(&buf, "To", to.String())
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader(&buf, "Date", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(msg.Text)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// maxHeaderLine — рекомендуемая длина строки письма без CRLF (RFC 5322, 2.1.1).
+const maxHeaderLine = 78
+
+// writeHeader пишет заголовок, перенося длинное значение по пробелам (folding, RFC 5322, 2.2.3).
+// Закодированные слова RFC 2047 пробелов не содержат, поэтому переносятся целиком; первое
+// слово может уйти на следующую строку, если не помещается рядом с именем заголовка.
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	line := name + ":"
+	for _, word := range strings.Split(value, " ") {
+		if len(line)+1+len(word) > maxHeaderLine {
+			buf.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	buf.WriteString(line + "\r\n")
+}
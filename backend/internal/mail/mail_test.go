@@ -0,0 +1,286 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	notice := struct {
+		Time      time.Time
+		IP        string
+		UserAgent string
+		Device    string
+	}{time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), "203.0.113.7", "Firefox", "Laptop"}
+	code := struct {
+		Code           string
+		ExpiresMinutes int
+	}{"123456", 15}
+
+	// Данные повторяют то, что передают обработчики; лишнее или пропущенное поле — ошибка шаблона.
+	tests := []struct {
+		name    string
+		data    any
+		subject string
+		want    []string
+	}{
+		{TemplateVerifyEmail, code, "Confirm your Passkeys email", []string{"123456", "15 minutes"}},
+		{TemplateEmailChange, code, "Confirm your new Passkeys email", []string{"123456", "15 minutes"}},
+		{TemplateEmailChanged, struct{ NewEmail string }{"new@example.com"}, "Your Passkeys email was changed", []string{"new@example.com"}},
+		{TemplateNewDeviceLogin, notice, "New sign-in to your Passkeys account", []string{"2024-05-01 12:30 UTC", "Laptop", "Firefox", "203.0.113.7"}},
+		{TemplatePasswordChanged, notice, "Your Passkeys master password was changed", []string{"2024-05-01 12:30 UTC", "203.0.113.7"}},
+		{TemplateEmergencyAccessInvite, struct {
+			GrantorEmail string
+			WaitDays     int
+		}{"grantor@example.com", 7}, "grantor@example.com invited you as an emergency contact", []string{"grantor@example.com wants", "after 7 day(s)"}},
+		{TemplateEmergencyAccessRequested, struct {
+			GranteeEmail string
+			WaitDays     int
+		}{"grantee@example.com", 3}, "Emergency access to your Passkeys vault was requested", []string{"grantee@example.com requested", "in 3 day(s)"}},
+	}
+
+	rendered := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Render("user@example.com", tt.name, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.To != "user@example.com" || msg.Subject != tt.subject {
+				t.Errorf("to %q subject %q, want %q", msg.To, msg.Subject, tt.subject)
+			}
+			if strings.HasPrefix(msg.Text, "\n") || strings.Contains(msg.Text, "Subject:") || strings.Contains(msg.Text, "<no value>") {
+				t.Errorf("text = %q", msg.Text)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(msg.Text, want) {
+					t.Errorf("text lacks %q:\n%s", want, msg.Text)
+				}
+			}
+		})
+		rendered[tt.name+".txt"] = true
+	}
+
+	// Каждый встроенный шаблон должен быть в таблице выше.
+	for _, tmpl := range templates.Templates() {
+		if !rendered[tmpl.Name()] {
+			t.Errorf("template %s is not tested", tmpl.Name())
+		}
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	if _, err := Render("user@example.com", "no_such_template", nil); err == nil {
+		t.Error("unknown template rendered")
+	}
+	// Шаблон требует поле Code, которого нет в данных.
+	if _, err := Render("user@example.com", TemplateVerifyEmail, struct{ Other string }{}); err == nil {
+		t.Error("template rendered with missing fields")
+	}
+}
+
+// parseMessage разбирает собранное письмо и декодирует тему и текст.
+func parseMessage(t *testing.T, data []byte) (*netmail.Message, string, string) {
+	t.Helper()
+	for i, line := range strings.Split(string(data), "\r\n") {
+		if line == "" {
+			break
+		}
+		if len(line) > maxHeaderLine {
+			t.Errorf("header line %d is %d characters: %q", i, len(line), line)
+		}
+		if strings.ContainsAny(line, "\r\n") {
+			t.Errorf("bare line break in header line %q", line)
+		}
+	}
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, subject, string(body)
+}
+
+func TestMessageBuild(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	long := strings.TrimSpace(strings.Repeat("Ваш код подтверждения и очень длинная тема письма ", 3))
+	tests := []struct {
+		name    string
+		subject string
+	}{
+		{"ascii", "Confirm your Passkeys email"},
+		{"utf-8", "Подтвердите адрес — ключ 🔑"},
+		{"long ascii", strings.TrimSpace(strings.Repeat("A long subject line ", 10))},
+		{"long utf-8", long},
+		{"header injection", "Hello\r\nBcc: victim@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := "Code: 123456\nПривет, мир = " + strings.Repeat("x", 100) + "\n"
+			data, err := Message{To: "Пользователь <user@example.com>", Subject: tt.subject, Text: text}.build("Passkeys <no-reply@example.com>", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, subject, body := parseMessage(t, data)
+
+			// Свёрнутая тема при разборе склеивается обратно.
+			if subject != tt.subject {
+				t.Errorf("subject = %q, want %q", subject, tt.subject)
+			}
+			if msg.Header.Get("Bcc") != "" {
+				t.Error("subject injected a Bcc header")
+			}
+			// В тексте письма переводы строк — CRLF.
+			if want := strings.ReplaceAll(text, "\n", "\r\n"); body != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+			to, err := msg.Header.AddressList("To")
+			if err != nil || len(to) != 1 || to[0].Address != "user@example.com" || to[0].Name != "Пользователь" {
+				t.Errorf("To = %v %v", to, err)
+			}
+			from, err := msg.Header.AddressList("From")
+			if err != nil || len(from) != 1 || from[0].Address != "no-reply@example.com" {
+				t.Errorf("From = %v %v", from, err)
+			}
+			if date, err := msg.Header.Date(); err != nil || !date.Equal(now) {
+				t.Errorf("Date = %v %v", date, err)
+			}
+			if msg.Header.Get("Content-Type") != "text/plain; charset=utf-8" || msg.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+				t.Errorf("headers = %v", msg.Header)
+			}
+		})
+	}
+}
+
+func TestMessageBuildRejectsAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		to      string
+		from    string
+		wantErr error
+	}{
+		{"empty recipient", "", "no-reply@example.com", ErrInvalidRecipient},
+		{"header in recipient", "user@example.com\r\nBcc: victim@example.com", "no-reply@example.com", ErrInvalidRecipient},
+		{"two recipients", "a@example.com, b@example.com", "no-reply@example.com", ErrInvalidRecipient},
+		{"bad sender", "user@example.com", "not an address", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Message{To: tt.to, Subject: "s", Text: "t"}.build(tt.from, time.Now())
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"short", "hello world", "Subject: hello world\r\n"},
+		{"empty", "", "Subject: \r\n"},
+		{
+			"folded",
+			strings.Repeat("word ", 20) + "end",
+			"Subject:" + strings.Repeat(" word", 14) + "\r\n" + strings.Repeat(" word", 6) + " end\r\n",
+		},
+		// Слово длиннее строки не режется: переносить можно только по пробелам.
+		{"long word", strings.Repeat("x", 100), "Subject:\r\n " + strings.Repeat("x", 100) + "\r\n"},
+		{"encoded words", "=?utf-8?q?" + strings.Repeat("a", 63) + "?= =?utf-8?q?b?=", "Subject:\r\n =?utf-8?q?" + strings.Repeat("a", 63) + "?=\r\n =?utf-8?q?b?=\r\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		writeHeader(&buf, "Subject", tt.value)
+		if buf.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer := FileMailer{Dir: dir, From: "Passkeys <no-reply@example.com>"}
+
+	msg, err := Render("user@example.com", TemplateVerifyEmail, struct {
+		Code           string
+		ExpiresMinutes int
+	}{"654321", 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := mailer.Send(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("files = %v %v, want two .eml files", files, err)
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, subject, body := parseMessage(t, data)
+	if subject != "Confirm your Passkeys email" || !strings.Contains(body, "654321") || parsed.Header.Get("To") != "<user@example.com>" {
+		t.Errorf("message: subject %q, to %q, body %q", subject, parsed.Header.Get("To"), body)
+	}
+
+	if err := mailer.Send(context.Background(), Message{To: "bad address", Subject: "s"}); !errors.Is(err, ErrInvalidRecipient) {
+		t.Errorf("bad recipient: err = %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.eml")); len(files) != 2 {
+		t.Errorf("rejected message was written: %v", files)
+	}
+}
+
+func TestLogMailerRedactsBody(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	msg := Message{To: "user@example.com", Subject: "Confirm your Passkeys email", Text: "Your email confirmation code: 987654\n"}
+	if err := (LogMailer{}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	logged := buf.String()
+	if strings.Contains(logged, "987654") {
+		t.Errorf("log contains the message body: %q", logged)
+	}
+	if !strings.Contains(logged, "user@example.com") || !strings.Contains(logged, "Confirm your Passkeys email") {
+		t.Errorf("log = %q, want recipient and subject", logged)
+	}
+}
@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// Шаблоны писем: первая строка — "Subject: ...", после пустой строки — текст.
+const (
+	TemplateVerifyEmail     = "verify_email"
+	TemplateEmailChange     = "email_change"
+	TemplateEmailChanged    = "email_changed"
+	TemplateNewDeviceLogin  = "new_device_login"
+	TemplatePasswordChanged = "password_changed"
+	// Экстренный доступ: приглашение доверенному контакту и запрос доступа к хранилищу.
+	TemplateEmergencyAccessInvite    = "emergency_access_invite"
+	TemplateEmergencyAccessRequested = "emergency_access_requested"
+)
+
+//go:embed templates/*.txt
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.txt"))
+
+var errBadTemplate = errors.New("mail template must start with a Subject line")
+
+// Render собирает письмо по шаблону name для адресата to.
+func Render(to, name string, data any) (Message, error) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name+".txt", data); err != nil {
+		return Message{}, err
+	}
+
+	header, body, ok := strings.Cut(buf.String(), "\n\n")
+	subject, found := strings.CutPrefix(header, "Subject: ")
+	if !ok || !found {
+		return Message{}, errBadTemplate
+	}
+	return Message{To: to, Subject: strings.TrimSpace(subject), Text: body}, nil
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"passkeys/internal/auth"
+)
+
+// RoleChecker сообщает текущую роль пользователя.
+type RoleChecker interface {
+	Role(ctx context.Context, userID string) (string, error)
+}
+
+// RequireAdmin пропускает только администраторов. Ставится после AuthMiddleware;
+// персональные токены сюда не допускаются, даже если принадлежат администратору.
+func RequireAdmin(roles RoleChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUser(r)
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if user.TokenID != "" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			role, err := roles.Role(r.Context(), user.ID)
+			if err != nil {
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if role != auth.RoleAdmin {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -12,12 +13,44 @@ type contextKey string
 
 const userKey contextKey = "user"
 
+// SessionChecker сообщает, не отозвана ли сессия, в которой выдан токен.
+type SessionChecker interface {
+	Active(ctx context.Context, sessionID string) (bool, error)
+}
+
+// PersonalTokenChecker находит действующий персональный токен доступа.
+type PersonalTokenChecker interface {
+	Lookup(ctx context.Context, token string) (auth.PersonalToken, error)
+}
+
 type UserContext struct {
 	ID    string
 	Email string
+	// SessionID пуст у токенов, выданных до появления сессий, и у персональных токенов.
+	SessionID string
+	// TokenID и Scopes заданы, если запрос пришёл с персональным токеном; у JWT доступ полный.
+	TokenID string
+	Scopes  []string
 }
 
-func AuthMiddleware(secret []byte) func(http.Handler) http.Handler {
+// HasScope — разрешено ли действие: JWT сессии разрешено всё, персональному токену — его scopes.
+func (u UserContext) HasScope(scope string) bool {
+	if u.TokenID == "" {
+		return true
+	}
+	for _, s := range u.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthMiddleware пропускает запросы с действующим access-токеном. Если sessions не nil,
+// токен отклоняется сразу после отзыва его сессии, не дожидаясь истечения срока.
+// Токены без сессии (выданные до её появления) живут до истечения срока.
+// Персональные токены принимаются, только если передан tokens; права проверяет RequireScope.
+func AuthMiddleware(keys *auth.KeySet, sessions SessionChecker, tokens PersonalTokenChecker) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -32,21 +65,78 @@ func AuthMiddleware(secret []byte) func(http.Handler) http.Handler {
 				return
 			}
 
-			claims, err := auth.ParseToken(secret, parts[1])
+			if tokens != nil && auth.IsPersonalToken(parts[1]) {
+				pt, err := tokens.Lookup(r.Context(), parts[1])
+				if errors.Is(err, auth.ErrInvalidPersonalToken) {
+					http.Error(w, "invalid token", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				ctx := context.WithValue(r.Context(), userKey, UserContext{
+					ID:      pt.UserID,
+					Email:   pt.Email,
+					TokenID: pt.ID,
+					Scopes:  pt.Scopes,
+				})
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			claims, err := auth.ParseToken(keys, parts[1])
 			if err != nil {
 				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
 			}
 
+			if sessions != nil && claims.SessionID != "" {
+				active, err := sessions.Active(r.Context(), claims.SessionID)
+				if err != nil {
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				if !active {
+					http.Error(w, "session revoked", http.StatusUnauthorized)
+					return
+				}
+			}
+
 			ctx := context.WithValue(r.Context(), userKey, UserContext{
-				ID:    claims.UserID,
-				Email: claims.Email,
+				ID:        claims.UserID,
+				Email:     claims.Email,
+				SessionID: claims.SessionID,
 			})
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// RequireScope проверяет право персонального токена на ресурс группы маршрутов:
+// GET и HEAD требуют "<resource>:read", остальные методы — "<resource>:write".
+func RequireScope(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUser(r)
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			scope := resource + ":write"
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = resource + ":read"
+			}
+			if !user.HasScope(scope) {
+				http.Error(w, "insufficient scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func GetUser(r *http.Request) (UserContext, bool) {
 	value := r.Context().Value(userKey)
 	user, ok := value.(UserContext)
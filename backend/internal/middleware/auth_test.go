@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"passkeys/internal/auth"
+)
+
+const testPersonalToken = auth.PersonalTokenPrefix + "test"
+
+// fakeTokens отдаёт один персональный токен с заданными scopes.
+type fakeTokens struct {
+	scopes []string
+}
+
+func (f fakeTokens) Lookup(_ context.Context, token string) (auth.PersonalToken, error) {
+	if token != testPersonalToken {
+		return auth.PersonalToken{}, auth.ErrInvalidPersonalToken
+	}
+	return auth.PersonalToken{ID: "token-1", UserID: "user-1", Email: "user@example.com", Scopes: f.scopes}, nil
+}
+
+// fakeSessions считает активными только перечисленные сессии.
+type fakeSessions map[string]bool
+
+func (f fakeSessions) Active(_ context.Context, sessionID string) (bool, error) {
+	return f[sessionID], nil
+}
+
+func testKeys(t *testing.T) *auth.KeySet {
+	t.Helper()
+	key, err := auth.GenerateSigningKey(auth.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.NewKeySet(key)
+}
+
+// okHandler отвечает 200 и id пользователя из контекста.
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	user, _ := GetUser(r)
+	w.Write([]byte(user.ID))
+})
+
+func serve(handler http.Handler, method, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequireScope(t *testing.T) {
+	keys := testKeys(t)
+	session, err := auth.CreateToken(keys, "user-1", "user@example.com", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		scopes   []string
+		resource string
+		method   string
+		// session — запрос с JWT сессии вместо персонального токена.
+		session bool
+		want    int
+	}{
+		{name: "read scope allows GET", scopes: []string{auth.ScopeNotesRead}, resource: "notes", method: http.MethodGet, want: http.StatusOK},
+		{name: "read scope allows HEAD", scopes: []string{auth.ScopeNotesRead}, resource: "notes", method: http.MethodHead, want: http.StatusOK},
+		{name: "read scope refuses POST", scopes: []string{auth.ScopeNotesRead}, resource: "notes", method: http.MethodPost, want: http.StatusForbidden},
+		{name: "read scope refuses PUT", scopes: []string{auth.ScopeNotesRead}, resource: "notes", method: http.MethodPut, want: http.StatusForbidden},
+		{name: "read scope refuses DELETE", scopes: []string{auth.ScopeNotesRead}, resource: "notes", method: http.MethodDelete, want: http.StatusForbidden},
+		{name: "read scope refuses another resource", scopes: []string{auth.ScopeNotesRead}, resource: "accounts", method: http.MethodGet, want: http.StatusForbidden},
+		{name: "write scope does not imply read", scopes: []string{auth.ScopeNotesWrite}, resource: "notes", method: http.MethodGet, want: http.StatusForbidden},
+		{name: "write scope allows POST", scopes: []string{auth.ScopeNotesWrite}, resource: "notes", method: http.MethodPost, want: http.StatusOK},
+		{name: "write scope refuses another resource", scopes: []string{auth.ScopeNotesWrite}, resource: "items", method: http.MethodDelete, want: http.StatusForbidden},
+		{name: "no scopes", scopes: nil, resource: "folders", method: http.MethodGet, want: http.StatusForbidden},
+		{name: "all scopes", scopes: auth.AllScopes, resource: "folders", method: http.MethodPut, want: http.StatusOK},
+		{name: "session token has full access", session: true, resource: "accounts", method: http.MethodDelete, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := AuthMiddleware(keys, fakeSessions{"session-1": true}, fakeTokens{scopes: tt.scopes})(RequireScope(tt.resource)(okHandler))
+			authorization := "Bearer " + testPersonalToken
+			if tt.session {
+				authorization = "Bearer " + session
+			}
+			if rec := serve(handler, tt.method, authorization); rec.Code != tt.want {
+				t.Errorf("status = %d %q, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireScopeWithoutUser(t *testing.T) {
+	if rec := serve(RequireScope("notes")(okHandler), http.MethodGet, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	keys := testKeys(t)
+	session, err := auth.CreateToken(keys, "user-1", "user@example.com", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := auth.CreateToken(keys, "user-1", "user@example.com", "session-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := auth.CreateTokenWithLifetime(keys, "user-1", "user@example.com", "session-1", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, err := auth.CreateMFAToken(keys, "user-1", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := auth.CreateToken(testKeys(t), "user-1", "user@example.com", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := fakeSessions{"session-1": true}
+	tokens := fakeTokens{scopes: auth.AllScopes}
+	// requireAuth — только сессии, requireAuthOrToken — ещё и персональные токены.
+	requireAuth := AuthMiddleware(keys, sessions, nil)(okHandler)
+	requireAuthOrToken := AuthMiddleware(keys, sessions, tokens)(okHandler)
+
+	tests := []struct {
+		name          string
+		handler       http.Handler
+		authorization string
+		want          int
+	}{
+		{"session on session route", requireAuth, "Bearer " + session, http.StatusOK},
+		{"lowercase scheme", requireAuth, "bearer " + session, http.StatusOK},
+		{"personal token on session route", requireAuth, "Bearer " + testPersonalToken, http.StatusUnauthorized},
+		{"personal token on token route", requireAuthOrToken, "Bearer " + testPersonalToken, http.StatusOK},
+		{"unknown personal token", requireAuthOrToken, "Bearer " + auth.PersonalTokenPrefix + "unknown", http.StatusUnauthorized},
+		{"revoked session", requireAuth, "Bearer " + revoked, http.StatusUnauthorized},
+		{"expired token", requireAuth, "Bearer " + expired, http.StatusUnauthorized},
+		{"mfa token as access token", requireAuthOrToken, "Bearer " + mfa, http.StatusUnauthorized},
+		{"token of other keys", requireAuth, "Bearer " + foreign, http.StatusUnauthorized},
+		{"missing header", requireAuth, "", http.StatusUnauthorized},
+		{"basic scheme", requireAuth, "Basic " + session, http.StatusUnauthorized},
+		{"no scheme", requireAuth, session, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handler, http.MethodGet, tt.authorization)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d %q, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if tt.want == http.StatusOK && rec.Body.String() != "user-1" {
+				t.Errorf("user = %q, want user-1", rec.Body)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	session := UserContext{ID: "user-1", SessionID: "session-1"}
+	if !session.HasScope(auth.ScopeItemsWrite) {
+		t.Error("session user lacks a scope")
+	}
+	token := UserContext{ID: "user-1", TokenID: "token-1", Scopes: []string{auth.ScopeItemsRead}}
+	if !token.HasScope(auth.ScopeItemsRead) || token.HasScope(auth.ScopeItemsWrite) || token.HasScope("items") {
+		t.Errorf("token scopes %v", token.Scopes)
+	}
+}
@@ -0,0 +1,396 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"passkeys/internal/oidc"
+	"passkeys/internal/oidc/oidctest"
+)
+
+const (
+	testClientID     = "passkeys"
+	testClientSecret = "test-secret"
+	testRedirectURL  = "https://app.example.com/sso/callback"
+)
+
+// newTestProvider поднимает oidctest за httptest.Server и клиента к нему.
+func newTestProvider(t *testing.T, clientSecret string) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	idp, err := oidctest.New(testClientID, clientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(idp)
+	t.Cleanup(server.Close)
+	idp.Issuer = server.URL
+
+	rp := oidc.New(oidc.Config{
+		Issuer:       server.URL + "/",
+		ClientID:     testClientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  testRedirectURL,
+		HTTPClient:   server.Client(),
+	})
+	return idp, rp
+}
+
+// authorize проходит /authorize, как браузер, и возвращает код и state со страницы возврата.
+func authorize(t *testing.T, rp *oidc.Provider, state, nonce, verifier string) (code string) {
+	t.Helper()
+	authURL, err := rp.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURL+"?") {
+		t.Fatalf("redirected to %s", location)
+	}
+	if got := location.Query().Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+	return location.Query().Get("code")
+}
+
+func randomString(t *testing.T) string {
+	t.Helper()
+	s, err := oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDiscovery(t *testing.T) {
+	idp, rp := newTestProvider(t, testClientSecret)
+
+	metadata, err := rp.Metadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Issuer != idp.Issuer || rp.Issuer() != idp.Issuer {
+		t.Errorf("issuer = %q / %q, want %q", metadata.Issuer, rp.Issuer(), idp.Issuer)
+	}
+	if metadata.AuthorizationEndpoint != idp.Issuer+"/authorize" ||
+		metadata.TokenEndpoint != idp.Issuer+"/token" ||
+		metadata.JWKSURI != idp.Issuer+"/jwks" {
+		t.Errorf("metadata = %+v", metadata)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp, err := oidctest.New(testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(idp)
+	defer server.Close()
+	idp.Issuer = "https://evil.example.com"
+
+	rp := oidc.New(oidc.Config{Issuer: server.URL, ClientID: testClientID, HTTPClient: server.Client()})
+	if _, err := rp.Metadata(context.Background()); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Metadata error = %v, want issuer mismatch", err)
+	}
+}
+
+func TestDiscoveryUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	rp := oidc.New(oidc.Config{Issuer: server.URL, ClientID: testClientID, HTTPClient: server.Client()})
+	if _, err := rp.Metadata(context.Background()); err == nil {
+		t.Error("Metadata succeeded without a discovery document")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	_, rp := newTestProvider(t, testClientSecret)
+
+	verifier := randomString(t)
+	authURL, err := rp.AuthCodeURL(context.Background(), "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        oidc.CodeChallenge(verifier),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if query.Get("code_challenge") == verifier {
+		t.Error("verifier sent as the challenge")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		clientSecret string
+	}{
+		{"confidential client", testClientSecret},
+		{"public client", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			idp, rp := newTestProvider(t, tt.clientSecret)
+			idp.User = oidctest.User{Subject: "subject-1", Email: "sso@example.com", EmailVerified: true, Name: "SSO User"}
+
+			nonce, verifier := randomString(t), randomString(t)
+			code := authorize(t, rp, randomString(t), nonce, verifier)
+
+			token, err := rp.Exchange(context.Background(), code, verifier, nonce)
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			want := oidc.IDToken{Issuer: idp.Issuer, Subject: "subject-1", Email: "sso@example.com", EmailVerified: true, Name: "SSO User"}
+			if token != want {
+				t.Errorf("token = %+v, want %+v", token, want)
+			}
+
+			// Код одноразовый.
+			if _, err := rp.Exchange(context.Background(), code, verifier, nonce); err == nil {
+				t.Error("code exchanged twice")
+			}
+		})
+	}
+}
+
+func TestExchangeFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		// exchange меняет аргументы Exchange.
+		exchange func(code, verifier, nonce *string)
+		wantErr  error
+	}{
+		{"wrong pkce verifier", func(_, verifier, _ *string) { *verifier += "x" }, nil},
+		{"missing pkce verifier", func(_, verifier, _ *string) { *verifier = "" }, nil},
+		{"unknown code", func(code, _, _ *string) { *code = "unknown" }, nil},
+		{"nonce mismatch", func(_, _, nonce *string) { *nonce = "another nonce" }, oidc.ErrNonceMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rp := newTestProvider(t, testClientSecret)
+			nonce, verifier := randomString(t), randomString(t)
+			code := authorize(t, rp, randomString(t), nonce, verifier)
+
+			tt.exchange(&code, &verifier, &nonce)
+			_, err := rp.Exchange(context.Background(), code, verifier, nonce)
+			if err == nil {
+				t.Fatal("Exchange succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExchangeWrongClientSecret(t *testing.T) {
+	idp, err := oidctest.New(testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(idp)
+	defer server.Close()
+	idp.Issuer = server.URL
+
+	rp := oidc.New(oidc.Config{
+		Issuer:       server.URL,
+		ClientID:     testClientID,
+		ClientSecret: "wrong",
+		RedirectURL:  testRedirectURL,
+		HTTPClient:   server.Client(),
+	})
+	nonce, verifier := randomString(t), randomString(t)
+	code := authorize(t, rp, randomString(t), nonce, verifier)
+	if _, err := rp.Exchange(context.Background(), code, verifier, nonce); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("Exchange error = %v, want invalid_client", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp, rp := newTestProvider(t, testClientSecret)
+	user := oidctest.User{Subject: "subject-1", Email: "sso@example.com", EmailVerified: true}
+	const nonce = "nonce-1"
+
+	tests := []struct {
+		name string
+		// claims меняет утверждения токена; kid — заголовок kid ("" — по умолчанию).
+		claims  func(claims jwt.MapClaims)
+		kid     string
+		wantErr error
+	}{
+		{name: "valid", claims: func(jwt.MapClaims) {}},
+		{name: "email_verified as string", claims: func(c jwt.MapClaims) { c["email_verified"] = "true" }},
+		{name: "within clock skew", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }},
+		{name: "bad iss", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: oidc.ErrInvalidIDToken},
+		{name: "missing iss", claims: func(c jwt.MapClaims) { delete(c, "iss") }, wantErr: oidc.ErrInvalidIDToken},
+		{name: "bad aud", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }, wantErr: oidc.ErrInvalidIDToken},
+		{name: "missing aud", claims: func(c jwt.MapClaims) { delete(c, "aud") }, wantErr: oidc.ErrInvalidIDToken},
+		{
+			name:    "several aud without azp",
+			claims:  func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "another-client"} },
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name: "several aud with azp",
+			claims: func(c jwt.MapClaims) {
+				c["aud"] = []string{testClientID, "another-client"}
+				c["azp"] = testClientID
+			},
+		},
+		{
+			name: "expired",
+			claims: func(c jwt.MapClaims) {
+				c["iat"] = time.Now().Add(-time.Hour).Unix()
+				c["exp"] = time.Now().Add(-10 * time.Minute).Unix()
+			},
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{name: "missing exp", claims: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: oidc.ErrInvalidIDToken},
+		{name: "issued in the future", claims: func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }, wantErr: oidc.ErrInvalidIDToken},
+		{name: "missing sub", claims: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: oidc.ErrInvalidIDToken},
+		{name: "nonce mismatch", claims: func(c jwt.MapClaims) { c["nonce"] = "another nonce" }, wantErr: oidc.ErrNonceMismatch},
+		{name: "missing nonce", claims: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: oidc.ErrNonceMismatch},
+		{name: "unknown kid", claims: func(jwt.MapClaims) {}, kid: "rotated-away", wantErr: oidc.ErrInvalidIDToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idp.Claims(user, nonce)
+			tt.claims(claims)
+			kid := tt.kid
+			if kid == "" {
+				kid = oidctest.KeyID
+			}
+			raw, err := idp.Sign(claims, kid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := rp.VerifyIDToken(context.Background(), raw, nonce)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("VerifyIDToken: %v", err)
+				}
+				if token.Subject != user.Subject || token.Email != user.Email || !token.EmailVerified {
+					t.Errorf("token = %+v", token)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsForgedSignatures(t *testing.T) {
+	idp, rp := newTestProvider(t, testClientSecret)
+	user := oidctest.User{Subject: "subject-1", Email: "sso@example.com", EmailVerified: true}
+	const nonce = "nonce-1"
+
+	valid, err := idp.IDToken(user, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.VerifyIDToken(context.Background(), valid, nonce); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	// alg=none без подписи.
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, idp.Claims(user, nonce))
+	unsigned.Header["kid"] = oidctest.KeyID
+	none, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HS256 с открытым ключом провайдера в роли секрета — классическая подмена алгоритма.
+	symmetric := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.Claims(user, nonce))
+	symmetric.Header["kid"] = oidctest.KeyID
+	hs256, err := symmetric.SignedString([]byte("any secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Тот же kid, но чужой ключ.
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign := jwt.NewWithClaims(jwt.SigningMethodEdDSA, idp.Claims(user, nonce))
+	foreign.Header["kid"] = oidctest.KeyID
+	otherSigned, err := foreign.SignedString(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+	signature := []byte(parts[2])
+	if signature[0] == 'A' {
+		signature[0] = 'B'
+	} else {
+		signature[0] = 'A'
+	}
+	tampered := parts[0] + "." + parts[1] + "." + string(signature)
+
+	for name, raw := range map[string]string{
+		"alg none":           none,
+		"alg none unsigned":  parts[0] + "." + parts[1] + ".",
+		"hs256":              hs256,
+		"foreign key":        otherSigned,
+		"tampered signature": tampered,
+		"garbage":            "not a jwt",
+	} {
+		if _, err := rp.VerifyIDToken(context.Background(), raw, nonce); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("%s: error = %v, want ErrInvalidIDToken", name, err)
+		}
+	}
+}
+
+func TestRandomStringAndCodeChallenge(t *testing.T) {
+	a, b := randomString(t), randomString(t)
+	if len(a) != 43 || a == b {
+		t.Errorf("RandomString = %q, %q", a, b)
+	}
+	// Сам S256 сверяется с провайдером в TestExchange; здесь — что challenge не раскрывает verifier.
+	challenge := oidc.CodeChallenge(a)
+	if len(challenge) != 43 || challenge == a || challenge != oidc.CodeChallenge(a) || challenge == oidc.CodeChallenge(b) {
+		t.Errorf("CodeChallenge(%q) = %q", a, challenge)
+	}
+}
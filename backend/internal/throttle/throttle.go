@@ -0,0 +1,135 @@
+// Package throttle ограничивает перебор на эндпоинтах аутентификации: после нескольких
+// бесплатных попыток каждая следующая неудача удваивает задержку, а после LockoutThreshold
+// неудач ключ (IP, email, пользователь) блокируется на LockoutDuration.
+package throttle
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DefaultFreeAttempts     = 3
+	DefaultBaseDelay        = time.Second
+	DefaultMaxDelay         = 5 * time.Minute
+	DefaultLockoutThreshold = 10
+	DefaultLockoutDuration  = 30 * time.Minute
+	// DefaultWindow — через сколько после последней неудачи счётчик начинается заново.
+	DefaultWindow = time.Hour
+)
+
+type Limiter struct {
+	DB               *pgxpool.Pool
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	Window           time.Duration
+}
+
+func New(db *pgxpool.Pool) *Limiter {
+	return &Limiter{
+		DB:               db,
+		FreeAttempts:     DefaultFreeAttempts,
+		BaseDelay:        DefaultBaseDelay,
+		MaxDelay:         DefaultMaxDelay,
+		LockoutThreshold: DefaultLockoutThreshold,
+		LockoutDuration:  DefaultLockoutDuration,
+		Window:           DefaultWindow,
+	}
+}
+
+// Key собирает ключ счётчика, например Key("email", "a@b.c").
+func Key(scope, value string) string {
+	return scope + ":" + value
+}
+
+// Check возвращает, сколько ещё ждать до следующей попытки (максимум по всем ключам); 0 — можно.
+func (l *Limiter) Check(ctx context.Context, keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		var lockedUntil *time.Time
+		err := l.DB.QueryRow(ctx, "select locked_until from auth_throttle where key=$1", key).Scan(&lockedUntil)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if lockedUntil != nil && lockedUntil.After(now) {
+			wait = max(wait, lockedUntil.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// Fail учитывает неудачную попытку по каждому ключу и возвращает наложенную задержку.
+func (l *Limiter) Fail(ctx context.Context, keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		var failures int
+		err := l.DB.QueryRow(ctx, `
+			insert into auth_throttle (key, failures, last_failure_at) values ($1, 1, $2)
+			on conflict (key) do update
+			set failures = case when auth_throttle.last_failure_at < $3 then 1 else auth_throttle.failures + 1 end,
+				last_failure_at = $2
+			returning failures`,
+			key, now, now.Add(-l.Window),
+		).Scan(&failures)
+		if err != nil {
+			return 0, err
+		}
+
+		delay := l.delay(failures)
+		if delay == 0 {
+			continue
+		}
+		if _, err := l.DB.Exec(ctx, "update auth_throttle set locked_until=$1 where key=$2", now.Add(delay), key); err != nil {
+			return 0, err
+		}
+		wait = max(wait, delay)
+	}
+	return wait, nil
+}
+
+// Reset сбрасывает счётчики после успешной попытки.
+func (l *Limiter) Reset(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if _, err := l.DB.Exec(ctx, "delete from auth_throttle where key=$1", key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup удаляет счётчики, которые уже не влияют ни на задержку, ни на блокировку.
+func (l *Limiter) Cleanup(ctx context.Context) error {
+	now := time.Now()
+	_, err := l.DB.Exec(ctx,
+		"delete from auth_throttle where last_failure_at<$1 and (locked_until is null or locked_until<$2)",
+		now.Add(-l.Window), now,
+	)
+	return err
+}
+
+func (l *Limiter) delay(failures int) time.Duration {
+	if failures >= l.LockoutThreshold {
+		return l.LockoutDuration
+	}
+	excess := failures - l.FreeAttempts
+	if excess <= 0 {
+		return 0
+	}
+	delay := l.BaseDelay
+	for i := 1; i < excess && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, l.MaxDelay)
+}
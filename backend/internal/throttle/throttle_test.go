@@ -0,0 +1,92 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayDefaults(t *testing.T) {
+	l := New(nil)
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		// Сброс (Reset или новое окно) начинает счёт заново: первые попытки бесплатны.
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 16 * time.Second},
+		{9, 32 * time.Second},
+		{10, 30 * time.Minute},
+		{11, 30 * time.Minute},
+		{1000, 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := l.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestDelayCappedByMaxDelay(t *testing.T) {
+	l := &Limiter{
+		FreeAttempts:     1,
+		BaseDelay:        time.Minute,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+	}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{6, 5 * time.Minute},
+		// Удвоение останавливается на MaxDelay и не переполняется на больших счётчиках.
+		{99, 5 * time.Minute},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := l.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestDelayNeverDecreases(t *testing.T) {
+	l := New(nil)
+	previous := time.Duration(0)
+	for failures := 0; failures <= 2*l.LockoutThreshold; failures++ {
+		got := l.delay(failures)
+		if got < previous {
+			t.Fatalf("delay(%d) = %v is less than delay(%d) = %v", failures, got, failures-1, previous)
+		}
+		previous = got
+	}
+}
+
+func TestDelayLockoutBeforeFreeAttemptsEnd(t *testing.T) {
+	// Порог блокировки срабатывает, даже если он не больше числа бесплатных попыток.
+	l := &Limiter{FreeAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute, LockoutThreshold: 3, LockoutDuration: time.Hour}
+	if got := l.delay(2); got != 0 {
+		t.Errorf("delay(2) = %v, want 0", got)
+	}
+	if got := l.delay(3); got != time.Hour {
+		t.Errorf("delay(3) = %v, want %v", got, time.Hour)
+	}
+}
+
+func TestKey(t *testing.T) {
+	if got := Key("email", "a@b.c"); got != "email:a@b.c" {
+		t.Errorf("Key = %q", got)
+	}
+}
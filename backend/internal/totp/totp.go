@@ -0,0 +1,103 @@
+// Package totp реализует одноразовые пароли по времени (RFC 6238) поверх HOTP (RFC 4226)
+// с параметрами, которые понимают все распространённые приложения-аутентификаторы:
+// HMAC-SHA1, 6 цифр, шаг 30 секунд.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Period — длительность шага в секундах.
+	Period = 30
+	// Digits — длина кода.
+	Digits = 6
+	// Skew — сколько соседних шагов принимаем, чтобы пережить рассинхрон часов.
+	Skew = 1
+	// SecretSize — длина секрета в байтах (160 бит, как рекомендует RFC 4226).
+	SecretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret — base32 без паддинга, в таком виде секрет вводят в приложение вручную.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI собирает otpauth://-ссылку для QR-кода.
+func URI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(Period))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// Step возвращает номер временного шага для момента t.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code вычисляет код HOTP для заданного шага.
+func Code(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// Validate проверяет код в окне ±Skew шагов вокруг now и возвращает шаг, которому он соответствует.
+// Вызывающий должен запомнить шаг и не принимать его повторно.
+func Validate(secret []byte, code string, now time.Time) (int64, bool) {
+	return ValidateAfter(secret, code, now, nil)
+}
+
+// ValidateAfter — Validate, который не принимает коды шага lastUsed и более ранних:
+// так уже предъявленный код нельзя повторить, пока он остаётся в окне.
+func ValidateAfter(secret []byte, code string, now time.Time, lastUsed *int64) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(now)
+	for delta := int64(-Skew); delta <= Skew; delta++ {
+		step := current + delta
+		if lastUsed != nil && step <= *lastUsed {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(Code(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
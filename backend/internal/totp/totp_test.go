@@ -0,0 +1,133 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret — ключ SHA1 из RFC 6238, приложение B.
+var rfcSecret = []byte("12345678901234567890")
+
+// Векторы RFC 6238 (приложение B, SHA1); у нас 6 цифр — последние 6 из 8.
+var rfcVectors = []struct {
+	unix int64
+	step int64
+	code string
+}{
+	{59, 0x1, "287082"},
+	{1111111109, 0x23523EC, "081804"},
+	{1111111111, 0x23523ED, "050471"},
+	{1234567890, 0x273EF07, "005924"},
+	{2000000000, 0x3F940AA, "279037"},
+	{20000000000, 0x27BC86AA, "353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		now := time.Unix(v.unix, 0)
+		if step := Step(now); step != v.step {
+			t.Errorf("Step(%d) = %#x, want %#x", v.unix, step, v.step)
+		}
+		if code := Code(rfcSecret, v.step); code != v.code {
+			t.Errorf("Code(step %#x) = %s, want %s", v.step, code, v.code)
+		}
+		step, ok := Validate(rfcSecret, v.code, now)
+		if !ok || step != v.step {
+			t.Errorf("Validate(%s at %d) = %#x, %v", v.code, v.unix, step, ok)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name  string
+		delta int64
+		valid bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, Code(rfcSecret, current+tt.delta), now)
+			if ok != tt.valid {
+				t.Fatalf("Validate = %v, want %v", ok, tt.valid)
+			}
+			if ok && step != current+tt.delta {
+				t.Errorf("step = %d, want %d", step, current+tt.delta)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", " 87082", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) accepted", code)
+		}
+	}
+	if _, ok := Validate([]byte("another secret"), "287082", now); ok {
+		t.Error("code accepted with another secret")
+	}
+}
+
+func TestValidateAfterRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	code := Code(rfcSecret, current)
+
+	step, ok := ValidateAfter(rfcSecret, code, now, nil)
+	if !ok || step != current {
+		t.Fatalf("first use = %d, %v", step, ok)
+	}
+	// Тот же код в том же шаге и пока он в окне (следующий шаг) принят быть не должен.
+	if _, ok := ValidateAfter(rfcSecret, code, now, &step); ok {
+		t.Error("code replayed in the same step")
+	}
+	if _, ok := ValidateAfter(rfcSecret, code, now.Add(Period*time.Second), &step); ok {
+		t.Error("code replayed in the next step")
+	}
+
+	// Код следующего шага после использования текущего принимается.
+	next := Code(rfcSecret, current+1)
+	if got, ok := ValidateAfter(rfcSecret, next, now, &step); !ok || got != current+1 {
+		t.Errorf("next step code = %d, %v", got, ok)
+	}
+
+	// Код прошлого шага не принимается, если уже использован более поздний.
+	previous := Code(rfcSecret, current-1)
+	if _, ok := ValidateAfter(rfcSecret, previous, now, &step); ok {
+		t.Error("older code accepted after a newer one")
+	}
+	older := current - 2
+	if got, ok := ValidateAfter(rfcSecret, previous, now, &older); !ok || got != current-1 {
+		t.Errorf("previous step code after an older use = %d, %v", got, ok)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Passkeys", "user@example.com", rfcSecret)
+	for _, part := range []string{
+		"otpauth://totp/Passkeys:user@example.com?",
+		"secret=" + EncodeSecret(rfcSecret),
+		"issuer=Passkeys",
+		"digits=6",
+		"period=30",
+		"algorithm=SHA1",
+	} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %q lacks %q", uri, part)
+		}
+	}
+	if strings.Contains(EncodeSecret(rfcSecret), "=") {
+		t.Error("encoded secret is padded")
+	}
+}
@@ -0,0 +1,130 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"passkeys/internal/cbor"
+)
+
+// Алгоритмы COSE (RFC 9053), которые принимаем при регистрации.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// Параметры ключей COSE.
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseRSAN   = -1
+	coseRSAE   = -2
+	ktyOKP     = 1
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// SupportedAlgorithms — pubKeyCredParams в порядке предпочтения.
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// ParsePublicKey разбирает COSE_Key одного из SupportedAlgorithms.
+func ParsePublicKey(coseKey []byte) (crypto.PublicKey, error) {
+	decoded, _, err := cbor.Decode(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: cose key is not a map")
+	}
+
+	alg, _ := key[int64(coseAlg)].(int64)
+	kty, _ := key[int64(coseKty)].(int64)
+
+	switch {
+	case alg == AlgES256 && kty == ktyEC2:
+		return ecdsaKey(key)
+	case alg == AlgEdDSA && kty == ktyOKP:
+		crv, _ := key[int64(coseCrv)].(int64)
+		x, _ := key[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("webauthn: invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case alg == AlgRS256 && kty == ktyRSA:
+		n, _ := key[int64(coseRSAN)].([]byte)
+		e, _ := key[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: invalid rsa key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	default:
+		return nil, fmt.Errorf("webauthn: unsupported key kty=%d alg=%d", kty, alg)
+	}
+}
+
+// VerifySignature проверяет подпись ключом, сохранённым в формате COSE_Key.
+func VerifySignature(coseKey, message, signature []byte) error {
+	pub, err := ParsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	var valid bool
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		valid = ecdsa.VerifyASN1(pub, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(pub, message, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func ecdsaKey(key map[any]any) (*ecdsa.PublicKey, error) {
+	crv, _ := key[int64(coseCrv)].(int64)
+	x, _ := key[int64(coseX)].([]byte)
+	y, _ := key[int64(coseY)].([]byte)
+	if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("webauthn: invalid p-256 key")
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("webauthn: point is not on curve")
+	}
+	return pub, nil
+}
+
+// EncodeES256Key кодирует открытый ключ P-256 в COSE_Key.
+func EncodeES256Key(pub *ecdsa.PublicKey) ([]byte, error) {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	return cbor.Encode(cbor.Map{
+		{Key: coseKty, Value: ktyEC2},
+		{Key: coseAlg, Value: AlgES256},
+		{Key: coseCrv, Value: crvP256},
+		{Key: coseX, Value: x},
+		{Key: coseY, Value: y},
+	})
+}
@@ -0,0 +1,277 @@
+// Package webauthn проверяет церемонии регистрации и подтверждения WebAuthn Level 2
+// для аттестации "none": клиентские данные, данные аутентификатора и подпись.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"passkeys/internal/cbor"
+)
+
+const (
+	ChallengeSize = 32
+	// Timeout — сколько браузер ждёт пользователя, мс.
+	Timeout = 120000
+)
+
+// Флаги authenticatorData.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+var (
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+	// ErrSignCount — счётчик не вырос: возможно, ключ клонирован.
+	ErrSignCount = errors.New("webauthn: sign count did not increase")
+)
+
+// Config описывает проверяющую сторону.
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+// Credential — результат успешной регистрации.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// Challenge достаёт challenge из clientDataJSON, чтобы найти сохранённую церемонию
+// до полной проверки ответа.
+func Challenge(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, err
+	}
+	var challenge Base64URL
+	if err := challenge.UnmarshalJSON([]byte(`"` + data.Challenge + `"`)); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (c Config) CreationOptions(challenge []byte, user UserEntity, exclude []CredentialDescriptor, residentKey string) CreationOptions {
+	params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	return CreationOptions{
+		Challenge:          challenge,
+		RP:                 RelyingParty{ID: c.RPID, Name: c.RPName},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            Timeout,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      residentKey,
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+}
+
+func (c Config) RequestOptions(challenge []byte, allow []CredentialDescriptor, userVerification string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout,
+		RPID:             c.RPID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// VerifyRegistration проверяет ответ create() (WebAuthn §7.1) и возвращает новый ключ.
+// Принимается только аттестация "none": доверие к модели аутентификатора не проверяем.
+func (c Config) VerifyRegistration(resp AttestationResponse, challenge []byte, requireUV bool) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, errors.New("webauthn: unexpected credential type")
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := cbor.Decode(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return nil, fmt.Errorf("webauthn: unsupported attestation format %q", format)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: missing authData")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+	if authData.CredentialID == nil {
+		return nil, errors.New("webauthn: no attested credential data")
+	}
+	if !bytes.Equal(authData.CredentialID, resp.RawID) {
+		return nil, errors.New("webauthn: credential id mismatch")
+	}
+
+	if _, err := ParsePublicKey(authData.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        authData.CredentialID,
+		PublicKey: authData.PublicKey,
+		SignCount: authData.SignCount,
+	}, nil
+}
+
+// VerifyAssertion проверяет ответ get() (WebAuthn §7.2) ключом publicKey и возвращает новый счётчик подписей.
+func (c Config) VerifyAssertion(resp AssertionResponse, challenge, publicKey []byte, storedSignCount uint32, requireUV bool) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, errors.New("webauthn: unexpected credential type")
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	message := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := VerifySignature(publicKey, message, resp.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Нулевой счётчик с обеих сторон означает, что аутентификатор его не ведёт (так делают многие passkeys).
+	if (authData.SignCount != 0 || storedSignCount != 0) && authData.SignCount <= storedSignCount {
+		return 0, ErrSignCount
+	}
+	return authData.SignCount, nil
+}
+
+func (c Config) verifyClientData(raw []byte, expectedType string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	if data.Type != expectedType {
+		return fmt.Errorf("webauthn: unexpected client data type %q", data.Type)
+	}
+	got, err := Challenge(raw)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return errors.New("webauthn: challenge mismatch")
+	}
+	if !slices.Contains(c.Origins, data.Origin) {
+		return fmt.Errorf("webauthn: origin %q is not allowed", data.Origin)
+	}
+	return nil
+}
+
+func (c Config) verifyAuthenticatorData(data authenticatorData, requireUV bool) error {
+	expected := sha256.Sum256([]byte(c.RPID))
+	if subtle.ConstantTimeCompare(data.RPIDHash, expected[:]) != 1 {
+		return errors.New("webauthn: rp id hash mismatch")
+	}
+	if data.Flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user not present")
+	}
+	if requireUV && data.Flags&flagUserVerified == 0 {
+		return errors.New("webauthn: user not verified")
+	}
+	return nil
+}
+
+func parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	var data authenticatorData
+	if len(raw) < 37 {
+		return data, errors.New("webauthn: authenticator data too short")
+	}
+	data.RPIDHash = raw[:32]
+	data.Flags = raw[32]
+	data.SignCount = binary.BigEndian.Uint32(raw[33:37])
+	rest := raw[37:]
+
+	if data.Flags&flagAttested != 0 {
+		// aaguid (16) + длина id (2)
+		if len(rest) < 18 {
+			return data, errors.New("webauthn: attested credential data too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return data, errors.New("webauthn: credential id truncated")
+		}
+		data.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, after, err := cbor.Decode(rest)
+		if err != nil {
+			return data, err
+		}
+		data.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if data.Flags&flagExtensions != 0 {
+		_, after, err := cbor.Decode(rest)
+		if err != nil {
+			return data, err
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return data, errors.New("webauthn: trailing bytes in authenticator data")
+	}
+	return data, nil
+}
@@ -0,0 +1,432 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"passkeys/internal/cbor"
+	"passkeys/internal/webauthn"
+	"passkeys/internal/webauthn/webauthntest"
+)
+
+const testOrigin = "https://passkeys.example.com"
+
+var testConfig = webauthn.Config{RPID: "passkeys.example.com", RPName: "Passkeys", Origins: []string{testOrigin}}
+
+func newChallenge(t *testing.T) []byte {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func testUser() webauthn.UserEntity {
+	return webauthn.UserEntity{ID: []byte("user-id"), Name: "user@example.com", DisplayName: "user@example.com"}
+}
+
+// register проводит регистрацию и возвращает ключ, как его сохранил бы сервер.
+func register(t *testing.T, config webauthn.Config, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge := newChallenge(t)
+	resp, err := authenticator.Create(config.CreationOptions(challenge, testUser(), nil, "required"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := testConfig.VerifyRegistration(resp, challenge, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return cred
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	authenticator := webauthntest.New(testOrigin)
+	cred := register(t, testConfig, authenticator)
+	if len(cred.ID) == 0 || cred.SignCount != 0 {
+		t.Fatalf("credential = %+v", cred)
+	}
+	if _, err := webauthn.ParsePublicKey(cred.PublicKey); err != nil {
+		t.Fatalf("stored public key: %v", err)
+	}
+
+	signCount := cred.SignCount
+	for i := 0; i < 3; i++ {
+		challenge := newChallenge(t)
+		allow := []webauthn.CredentialDescriptor{{Type: "public-key", ID: cred.ID}}
+		resp, err := authenticator.Get(testConfig.RequestOptions(challenge, allow, "required"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resp.RawID, cred.ID) || string(resp.Response.UserHandle) != "user-id" {
+			t.Fatalf("assertion for %x, user %q", resp.RawID, resp.Response.UserHandle)
+		}
+		got, err := webauthn.Challenge(resp.Response.ClientDataJSON)
+		if err != nil || !bytes.Equal(got, challenge) {
+			t.Fatalf("Challenge = %x, %v", got, err)
+		}
+
+		newCount, err := testConfig.VerifyAssertion(resp, challenge, cred.PublicKey, signCount, true)
+		if err != nil {
+			t.Fatalf("VerifyAssertion #%d: %v", i, err)
+		}
+		if newCount <= signCount {
+			t.Fatalf("sign count %d after %d", newCount, signCount)
+		}
+		signCount = newCount
+	}
+}
+
+func TestDiscoverableAssertion(t *testing.T) {
+	authenticator := webauthntest.New(testOrigin)
+	cred := register(t, testConfig, authenticator)
+
+	challenge := newChallenge(t)
+	resp, err := authenticator.Get(testConfig.RequestOptions(challenge, nil, "required"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testConfig.VerifyAssertion(resp, challenge, cred.PublicKey, cred.SignCount, true); err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+}
+
+func TestVerifyRegistrationFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare готовит аутентификатор и параметры create().
+		prepare func(a *webauthntest.Authenticator, options *webauthn.CreationOptions)
+		// tamper меняет ответ и challenge, с которым он проверяется.
+		tamper    func(resp *webauthn.AttestationResponse, challenge *[]byte)
+		requireUV bool
+		// want — фрагмент ожидаемой ошибки.
+		want string
+	}{
+		{
+			name: "wrong origin",
+			want: "origin",
+			prepare: func(a *webauthntest.Authenticator, _ *webauthn.CreationOptions) {
+				a.Origin = "https://evil.example.com"
+			},
+		},
+		{
+			name: "wrong rp id",
+			want: "rp id hash mismatch",
+			prepare: func(_ *webauthntest.Authenticator, options *webauthn.CreationOptions) {
+				options.RP.ID = "evil.example.com"
+			},
+		},
+		{
+			name:   "challenge mismatch",
+			want:   "challenge mismatch",
+			tamper: func(_ *webauthn.AttestationResponse, challenge *[]byte) { (*challenge)[0] ^= 0xff },
+		},
+		{
+			name:      "user verification required",
+			want:      "user not verified",
+			prepare:   func(a *webauthntest.Authenticator, _ *webauthn.CreationOptions) { a.UserVerified = false },
+			requireUV: true,
+		},
+		{
+			name: "assertion client data",
+			want: "unexpected client data type",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.ClientDataJSON = bytes.Replace(resp.Response.ClientDataJSON, []byte("webauthn.create"), []byte("webauthn.get"), 1)
+			},
+		},
+		{
+			name:   "credential id mismatch",
+			want:   "credential id mismatch",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) { resp.RawID = []byte("another id") },
+		},
+		{
+			name:   "wrong type",
+			want:   "unexpected credential type",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) { resp.Type = "password" },
+		},
+		{
+			name: "truncated attestation object",
+			want: "unexpected end of data",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.AttestationObject = resp.Response.AttestationObject[:len(resp.Response.AttestationObject)-10]
+			},
+		},
+		{
+			name: "attestation object is not cbor",
+			want: "cbor:",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.AttestationObject = []byte{0xff, 0x00, 0x01}
+			},
+		},
+		{
+			name: "attestation object is not a map",
+			want: "not a map",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.AttestationObject = mustEncode(t, []any{"none"})
+			},
+		},
+		{
+			name: "unsupported attestation format",
+			want: "unsupported attestation format",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.AttestationObject = replaceAttestation(t, resp.Response.AttestationObject, "packed", nil)
+			},
+		},
+		{
+			name: "authData without credential",
+			want: "no attested credential data",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.AttestationObject = replaceAttestation(t, resp.Response.AttestationObject, "none", func(authData []byte) []byte {
+					short := append([]byte(nil), authData[:37]...)
+					short[32] &^= 0x40
+					return short
+				})
+			},
+		},
+		{
+			name: "authData with trailing bytes",
+			want: "trailing bytes",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.AttestationObject = replaceAttestation(t, resp.Response.AttestationObject, "none", func(authData []byte) []byte {
+					return append(authData, 0x00)
+				})
+			},
+		},
+		{
+			name: "authData with truncated credential id",
+			want: "credential id truncated",
+			tamper: func(resp *webauthn.AttestationResponse, _ *[]byte) {
+				resp.Response.AttestationObject = replaceAttestation(t, resp.Response.AttestationObject, "none", func(authData []byte) []byte {
+					return authData[:37+18+4]
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := webauthntest.New(testOrigin)
+			challenge := newChallenge(t)
+			options := testConfig.CreationOptions(challenge, testUser(), nil, "required")
+			if tt.prepare != nil {
+				tt.prepare(authenticator, &options)
+			}
+			resp, err := authenticator.Create(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(&resp, &challenge)
+			}
+			cred, err := testConfig.VerifyRegistration(resp, challenge, tt.requireUV)
+			if err == nil {
+				t.Fatalf("VerifyRegistration accepted: %+v", cred)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		prepare         func(a *webauthntest.Authenticator, options *webauthn.RequestOptions)
+		tamper          func(resp *webauthn.AssertionResponse, challenge *[]byte)
+		storedSignCount uint32
+		requireUV       bool
+		wantErr         error
+		want            string
+	}{
+		{
+			name:    "wrong origin",
+			want:    "origin",
+			prepare: func(a *webauthntest.Authenticator, _ *webauthn.RequestOptions) { a.Origin = "https://evil.example.com" },
+		},
+		{
+			name: "wrong rp id hash",
+			want: "rp id hash mismatch",
+			tamper: func(resp *webauthn.AssertionResponse, _ *[]byte) {
+				resp.Response.AuthenticatorData[0] ^= 0xff
+			},
+		},
+		{
+			name:   "challenge mismatch",
+			want:   "challenge mismatch",
+			tamper: func(_ *webauthn.AssertionResponse, challenge *[]byte) { (*challenge)[0] ^= 0xff },
+		},
+		{
+			name: "registration client data",
+			want: "unexpected client data type",
+			tamper: func(resp *webauthn.AssertionResponse, _ *[]byte) {
+				resp.Response.ClientDataJSON = bytes.Replace(resp.Response.ClientDataJSON, []byte("webauthn.get"), []byte("webauthn.create"), 1)
+			},
+		},
+		{
+			name:            "sign count went backwards",
+			want:            "sign count",
+			storedSignCount: 5,
+			wantErr:         webauthn.ErrSignCount,
+		},
+		{
+			name:            "sign count did not change",
+			want:            "sign count",
+			storedSignCount: 1,
+			wantErr:         webauthn.ErrSignCount,
+		},
+		{
+			name:      "user verification required",
+			want:      "user not verified",
+			prepare:   func(a *webauthntest.Authenticator, _ *webauthn.RequestOptions) { a.UserVerified = false },
+			requireUV: true,
+		},
+		{
+			name: "user not present",
+			want: "user not present",
+			tamper: func(resp *webauthn.AssertionResponse, _ *[]byte) {
+				resp.Response.AuthenticatorData[32] &^= 0x01
+			},
+		},
+		{
+			name: "tampered signature",
+			want: "invalid signature",
+			tamper: func(resp *webauthn.AssertionResponse, _ *[]byte) {
+				resp.Response.Signature[len(resp.Response.Signature)-1] ^= 0x01
+			},
+		},
+		{
+			name: "signature over other data",
+			want: "invalid signature",
+			tamper: func(resp *webauthn.AssertionResponse, _ *[]byte) {
+				// Счётчик подписан: его подмена ломает подпись.
+				resp.Response.AuthenticatorData[36]++
+			},
+			wantErr: webauthn.ErrInvalidSignature,
+		},
+		{
+			name: "truncated authenticator data",
+			want: "too short",
+			tamper: func(resp *webauthn.AssertionResponse, _ *[]byte) {
+				resp.Response.AuthenticatorData = resp.Response.AuthenticatorData[:36]
+			},
+		},
+		{
+			name: "client data is not json",
+			want: "JSON",
+			tamper: func(resp *webauthn.AssertionResponse, _ *[]byte) {
+				resp.Response.ClientDataJSON = []byte("{")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := webauthntest.New(testOrigin)
+			cred := register(t, testConfig, authenticator)
+
+			challenge := newChallenge(t)
+			options := testConfig.RequestOptions(challenge, []webauthn.CredentialDescriptor{{Type: "public-key", ID: cred.ID}}, "preferred")
+			if tt.prepare != nil {
+				tt.prepare(authenticator, &options)
+			}
+			resp, err := authenticator.Get(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(&resp, &challenge)
+			}
+
+			_, err = testConfig.VerifyAssertion(resp, challenge, cred.PublicKey, tt.storedSignCount, tt.requireUV)
+			if err == nil {
+				t.Fatal("VerifyAssertion accepted")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionWithAnotherKey(t *testing.T) {
+	authenticator := webauthntest.New(testOrigin)
+	register(t, testConfig, authenticator)
+	other := register(t, testConfig, webauthntest.New(testOrigin))
+
+	challenge := newChallenge(t)
+	resp, err := authenticator.Get(testConfig.RequestOptions(challenge, nil, "preferred"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testConfig.VerifyAssertion(resp, challenge, other.PublicKey, 0, false); !errors.Is(err, webauthn.ErrInvalidSignature) {
+		t.Errorf("error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestChallengeMalformed(t *testing.T) {
+	for _, raw := range []string{"", "{", `{"challenge":"!!!"}`, `{"challenge":1}`} {
+		if _, err := webauthn.Challenge([]byte(raw)); err == nil {
+			t.Errorf("Challenge(%q) accepted", raw)
+		}
+	}
+	// Браузеры иногда присылают base64url с паддингом.
+	got, err := webauthn.Challenge([]byte(`{"challenge":"AQID"}`))
+	if err != nil || !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("Challenge = %x, %v", got, err)
+	}
+}
+
+func mustEncode(t *testing.T, value any) []byte {
+	t.Helper()
+	encoded, err := cbor.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// replaceAttestation пересобирает объект аттестации с другим форматом и изменённым authData.
+func replaceAttestation(t *testing.T, attestationObject []byte, format string, editAuthData func([]byte) []byte) []byte {
+	t.Helper()
+	decoded, _, err := cbor.Decode(attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authData := decoded.(map[any]any)["authData"].([]byte)
+	if editAuthData != nil {
+		authData = editAuthData(authData)
+	}
+	return mustEncode(t, cbor.Map{
+		{Key: "fmt", Value: format},
+		{Key: "attStmt", Value: cbor.Map{}},
+		{Key: "authData", Value: authData},
+	})
+}
+
+func TestCreationOptions(t *testing.T) {
+	challenge := newChallenge(t)
+	options := testConfig.CreationOptions(challenge, testUser(), nil, "preferred")
+	if options.RP.ID != testConfig.RPID || options.Attestation != "none" || len(options.PubKeyCredParams) == 0 {
+		t.Errorf("options = %+v", options)
+	}
+	for _, param := range options.PubKeyCredParams {
+		if param.Type != "public-key" {
+			t.Errorf("param type %q", param.Type)
+		}
+	}
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"challenge":"` + base64.RawURLEncoding.EncodeToString(challenge) + `"`
+	if !strings.Contains(string(encoded), want) {
+		t.Errorf("options JSON %s lacks %s", encoded, want)
+	}
+}
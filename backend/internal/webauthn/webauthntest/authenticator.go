@@ -0,0 +1,154 @@
+// Package webauthntest — программный аутентификатор для проверки церемоний WebAuthn
+// в Go без браузера и физического ключа, по аналогии с net/http/httptest.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"passkeys/internal/cbor"
+	"passkeys/internal/webauthn"
+)
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+// Authenticator хранит ключи ES256 в памяти и отвечает от имени origin.
+type Authenticator struct {
+	Origin string
+	// UserVerified выставляет флаг UV в ответах.
+	UserVerified bool
+	credentials  []*credential
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerified: true}
+}
+
+// Create выполняет navigator.credentials.create() с аттестацией "none".
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.AttestationResponse, error) {
+	var resp webauthn.AttestationResponse
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return resp, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return resp, err
+	}
+	cred := &credential{id: id, key: key, rpID: options.RP.ID, userHandle: options.User.ID}
+
+	coseKey, err := webauthn.EncodeES256Key(&key.PublicKey)
+	if err != nil {
+		return resp, err
+	}
+	attested := make([]byte, 16, 16+2+len(id)+len(coseKey)) // нулевой aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, coseKey...)
+
+	authData := a.authenticatorData(cred, 0x40)
+	authData = append(authData, attested...)
+
+	attestationObject, err := cbor.Encode(cbor.Map{
+		{Key: "fmt", Value: "none"},
+		{Key: "attStmt", Value: cbor.Map{}},
+		{Key: "authData", Value: authData},
+	})
+	if err != nil {
+		return resp, err
+	}
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return resp, err
+	}
+
+	a.credentials = append(a.credentials, cred)
+
+	resp.ID = base64.RawURLEncoding.EncodeToString(id)
+	resp.RawID = id
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AttestationObject = attestationObject
+	return resp, nil
+}
+
+// Get выполняет navigator.credentials.get(). При пустом allowCredentials выбирается
+// первый ключ для rpId, как у discoverable credentials.
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	var resp webauthn.AssertionResponse
+
+	cred := a.find(options)
+	if cred == nil {
+		return resp, errors.New("webauthntest: no matching credential")
+	}
+	cred.signCount++
+
+	authData := a.authenticatorData(cred, 0)
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return resp, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return resp, err
+	}
+
+	resp.ID = base64.RawURLEncoding.EncodeToString(cred.id)
+	resp.RawID = cred.id
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = signature
+	resp.Response.UserHandle = cred.userHandle
+	return resp, nil
+}
+
+func (a *Authenticator) find(options webauthn.RequestOptions) *credential {
+	for _, cred := range a.credentials {
+		if cred.rpID != options.RPID {
+			continue
+		}
+		if len(options.AllowCredentials) == 0 {
+			return cred
+		}
+		for _, allowed := range options.AllowCredentials {
+			if string(allowed.ID) == string(cred.id) {
+				return cred
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) authenticatorData(cred *credential, extraFlags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	flags := byte(0x01) | extraFlags
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, cred.signCount)
+}
+
+func (a *Authenticator) clientData(typ string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.Origin,
+	})
+}
@@ -0,0 +1,3 @@
+-- password_hash у старых пользователей — bcrypt от самого мастер-пароля ('password'),
+-- у новых — bcrypt от auth-хэша, вычисленного на клиенте ('auth_hash').
+alter table users add column if not exists auth_scheme text not null default 'password';
@@ -0,0 +1,6 @@
+-- Параметры KDF хранятся per-user, чтобы можно было поднимать стоимость или сменить
+-- алгоритм без поломки старых хранилищ. kdf_memory — в KiB, только для argon2id.
+alter table users add column if not exists kdf_algorithm text not null default 'pbkdf2-sha256';
+alter table users add column if not exists kdf_iterations integer not null default 100000;
+alter table users add column if not exists kdf_memory integer;
+alter table users add column if not exists kdf_parallelism integer;
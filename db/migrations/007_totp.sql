@@ -0,0 +1,9 @@
+create table if not exists user_totp (
+  user_id uuid primary key references users(id) on delete cascade,
+  secret_cipher bytea not null,
+  secret_nonce bytea not null,
+  confirmed_at timestamptz,
+  -- последний принятый шаг, чтобы один и тот же код нельзя было использовать дважды
+  last_used_step bigint,
+  created_at timestamptz not null default now()
+);
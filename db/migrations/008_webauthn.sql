@@ -0,0 +1,25 @@
+create table if not exists webauthn_credentials (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references users(id) on delete cascade,
+  credential_id bytea not null unique,
+  -- открытый ключ в формате COSE_Key
+  public_key bytea not null,
+  sign_count bigint not null default 0,
+  transports text[] not null default '{}',
+  name text not null default '',
+  created_at timestamptz not null default now(),
+  last_used_at timestamptz
+);
+
+create index if not exists webauthn_credentials_user_id_idx on webauthn_credentials(user_id);
+
+-- Выданные, но ещё не использованные challenge; одноразовые, удаляются при проверке ответа.
+create table if not exists webauthn_challenges (
+  challenge bytea primary key,
+  user_id uuid references users(id) on delete cascade,
+  purpose text not null,
+  expires_at timestamptz not null,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists webauthn_challenges_expires_at_idx on webauthn_challenges(expires_at);
@@ -0,0 +1,11 @@
+-- Одноразовые коды восстановления на случай потери второго фактора.
+-- Как и refresh_tokens.token_hash, хранится только sha256 кода.
+create table if not exists recovery_codes (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references users(id) on delete cascade,
+  code_hash text not null,
+  used_at timestamptz,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists recovery_codes_user_id_idx on recovery_codes(user_id);
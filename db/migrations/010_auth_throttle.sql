@@ -0,0 +1,10 @@
+-- Счётчики неудачных попыток входа по IP, email и пользователю. Хранятся в Postgres,
+-- чтобы ограничение работало одинаково на всех репликах API.
+create table if not exists auth_throttle (
+  key text primary key,
+  failures integer not null default 0,
+  last_failure_at timestamptz not null default now(),
+  locked_until timestamptz
+);
+
+create index if not exists auth_throttle_last_failure_at_idx on auth_throttle(last_failure_at);
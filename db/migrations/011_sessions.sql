@@ -0,0 +1,23 @@
+-- Сессия — вход с одного устройства; refresh-токены ротируются внутри неё, а id сессии остаётся.
+create table if not exists sessions (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references users(id) on delete cascade,
+  device_name text not null default '',
+  user_agent text not null default '',
+  ip text not null default '',
+  created_at timestamptz not null default now(),
+  last_used_at timestamptz not null default now()
+);
+
+create index if not exists sessions_user_id_idx on sessions(user_id);
+
+alter table refresh_tokens add column if not exists session_id uuid references sessions(id) on delete cascade;
+
+-- Уже выданные refresh-токены становятся отдельными сессиями с тем же id.
+insert into sessions (id, user_id, created_at, last_used_at)
+select id, user_id, created_at, created_at from refresh_tokens where session_id is null
+on conflict (id) do nothing;
+update refresh_tokens set session_id=id where session_id is null;
+
+alter table refresh_tokens alter column session_id set not null;
+create index if not exists refresh_tokens_session_id_idx on refresh_tokens(session_id);
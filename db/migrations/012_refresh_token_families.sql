@@ -0,0 +1,16 @@
+-- Ротированные refresh-токены не удаляются, а помечаются revoked_at: повторное предъявление
+-- такого токена означает утечку, и вся семья (сессия) отзывается.
+alter table refresh_tokens add column if not exists revoked_at timestamptz;
+
+-- Журнал событий безопасности (повторное использование токенов и т.п.).
+create table if not exists security_events (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid references users(id) on delete cascade,
+  event text not null,
+  ip text not null default '',
+  user_agent text not null default '',
+  details text not null default '',
+  created_at timestamptz not null default now()
+);
+
+create index if not exists security_events_user_id_idx on security_events(user_id);
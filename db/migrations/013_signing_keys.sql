@@ -0,0 +1,14 @@
+-- Ключи подписи JWT. Закрытый ключ (PKCS#8) зашифрован ключом, выведенным из JWT_SECRET
+-- или JWT_KEY_ENCRYPTION_KEY. Новый ключ публикуется в JWKS до activates_at, чтобы
+-- другие сервисы и реплики успели его получить; после retired_at ключ только проверяет
+-- ранее выданные токены.
+create table if not exists signing_keys (
+  id text primary key,
+  algorithm text not null,
+  public_key bytea not null,
+  private_key_cipher bytea not null,
+  private_key_nonce bytea not null,
+  created_at timestamptz not null default now(),
+  activates_at timestamptz not null default now(),
+  retired_at timestamptz
+);
@@ -0,0 +1,5 @@
+-- Запрошенное удаление аккаунта: до delete_after аккаунт отключён, но его можно восстановить,
+-- после — фоновая задача удаляет пользователя, а данные уходят каскадом.
+alter table users add column if not exists delete_after timestamptz;
+
+create index if not exists users_delete_after_idx on users(delete_after) where delete_after is not null;
@@ -0,0 +1,9 @@
+-- Незавершённая смена email: код подтверждения отправлен на новый адрес, хранится только его хэш.
+-- Новый запрос пользователя заменяет предыдущий.
+create table if not exists email_changes (
+  user_id uuid primary key references users(id) on delete cascade,
+  new_email text not null,
+  token_hash text not null,
+  expires_at timestamptz not null,
+  created_at timestamptz not null default now()
+);
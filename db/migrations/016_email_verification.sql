@@ -0,0 +1,20 @@
+-- Подтверждение email. Адреса уже существующих пользователей считаются подтверждёнными:
+-- default заполняет только имеющиеся строки и сразу снимается.
+alter table users add column if not exists email_verified_at timestamptz default now();
+alter table users alter column email_verified_at drop default;
+
+create table if not exists email_verifications (
+  user_id uuid primary key references users(id) on delete cascade,
+  token_hash text not null,
+  expires_at timestamptz not null,
+  created_at timestamptz not null default now()
+);
+
+-- Устройства, с которых уже входили: вход с нового устройства — повод для уведомления.
+create table if not exists login_devices (
+  user_id uuid not null references users(id) on delete cascade,
+  device_hash text not null,
+  created_at timestamptz not null default now(),
+  last_seen_at timestamptz not null default now(),
+  primary key (user_id, device_hash)
+);
@@ -0,0 +1,13 @@
+-- Персональные токены доступа для скриптов и CLI: ограничены scopes, хранится только хэш.
+create table if not exists personal_access_tokens (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references users(id) on delete cascade,
+  name text not null,
+  token_hash text not null unique,
+  scopes text[] not null,
+  expires_at timestamptz,
+  last_used_at timestamptz,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists personal_access_tokens_user_id_idx on personal_access_tokens(user_id);
@@ -0,0 +1,15 @@
+-- OAuth 2.0 Device Authorization Grant (RFC 8628): устройство без браузера получает
+-- device_code и user_code, пользователь подтверждает user_code в расширении.
+create table if not exists device_authorizations (
+  id uuid primary key default gen_random_uuid(),
+  device_code_hash text not null unique,
+  user_code text not null unique,
+  client_id text not null default '',
+  -- pending, approved или denied
+  status text not null default 'pending',
+  user_id uuid references users(id) on delete cascade,
+  poll_interval int not null,
+  last_polled_at timestamptz,
+  expires_at timestamptz not null,
+  created_at timestamptz not null default now()
+);
@@ -0,0 +1,26 @@
+-- Пара ключей пользователя для передачи ему чужих ключей хранилища. Открытый ключ хранится как есть,
+-- закрытый — зашифрованным ключом хранилища владельца (сервер его не видит).
+alter table users add column if not exists public_key bytea;
+alter table users add column if not exists private_key_cipher bytea;
+alter table users add column if not exists private_key_nonce bytea;
+
+-- Экстренный доступ: grantor доверяет grantee доступ к своему хранилищу после периода ожидания.
+create table if not exists emergency_access (
+  id uuid primary key default gen_random_uuid(),
+  grantor_id uuid not null references users(id) on delete cascade,
+  -- grantee_id заполняется, когда приглашённый принимает приглашение
+  grantee_id uuid references users(id) on delete cascade,
+  grantee_email text not null,
+  -- invited, accepted, confirmed, recovery_initiated или recovery_approved
+  status text not null default 'invited',
+  wait_days int not null,
+  -- ключ хранилища grantor, зашифрованный открытым ключом grantee
+  key_encrypted bytea,
+  recovery_initiated_at timestamptz,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  unique (grantor_id, grantee_email)
+);
+
+create index if not exists emergency_access_grantee_id_idx on emergency_access(grantee_id);
+create index if not exists emergency_access_grantee_email_idx on emergency_access(lower(grantee_email));
@@ -0,0 +1,3 @@
+-- Роль пользователя (user или admin) и отключение аккаунта администратором.
+alter table users add column if not exists role text not null default 'user';
+alter table users add column if not exists disabled_at timestamptz;
@@ -0,0 +1,26 @@
+-- Учётные записи внешнего OpenID-провайдера, привязанные к пользователям.
+create table if not exists oidc_identities (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references users(id) on delete cascade,
+  issuer text not null,
+  subject text not null,
+  email text not null default '',
+  created_at timestamptz not null default now(),
+  last_login_at timestamptz,
+  unique (issuer, subject)
+);
+
+create index if not exists oidc_identities_user_id_idx on oidc_identities(user_id);
+
+-- Начатые входы: state, nonce и PKCE code_verifier живут до возврата от провайдера.
+-- user_id задан, если вход начат для привязки учётной записи к уже вошедшему пользователю.
+create table if not exists oidc_logins (
+  state_hash text primary key,
+  user_id uuid references users(id) on delete cascade,
+  nonce text not null,
+  code_verifier text not null,
+  expires_at timestamptz not null,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists oidc_logins_expires_at_idx on oidc_logins(expires_at);
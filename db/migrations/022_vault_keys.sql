@@ -0,0 +1,6 @@
+-- Ключ хранилища, зашифрованный ключом из мастер-пароля: смена пароля перешифровывает только его.
+-- Пустой vault_key_cipher — записи зашифрованы прямо ключом из пароля (хранилища до перехода).
+alter table users add column if not exists vault_key_cipher bytea;
+alter table users add column if not exists vault_key_nonce bytea;
+-- Поколение ключа хранилища: растёт, когда записи перешифрованы новым ключом.
+alter table users add column if not exists vault_key_version int not null default 0;
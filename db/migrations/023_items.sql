@@ -0,0 +1,64 @@
+-- Записи хранилища любого типа: тип, открытые метаданные и конверт зашифрованных полей
+-- ({"имя поля": {"cipher": base64, "nonce": base64}}). /accounts и /notes — представления над items.
+create table if not exists items (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references users(id) on delete cascade,
+  type text not null,
+  metadata jsonb not null default '{}',
+  payload jsonb not null,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+
+create index if not exists items_user_id_type_idx on items(user_id, type);
+
+-- Перенос accounts и notes с сохранением id: клиенты продолжают работать с теми же записями.
+-- Старая таблица удаляется, только если перенесены все её строки; иначе блок откатывается целиком.
+do $$
+declare
+  copied bigint;
+  total bigint;
+begin
+  if to_regclass('accounts') is not null then
+    insert into items (id, user_id, type, metadata, payload, created_at, updated_at)
+    select id, user_id, 'login',
+      jsonb_build_object('url', url, 'label', label),
+      jsonb_build_object(
+        'username', jsonb_build_object(
+          'cipher', translate(encode(username_cipher, 'base64'), E'\n', ''),
+          'nonce', translate(encode(username_nonce, 'base64'), E'\n', '')),
+        'password', jsonb_build_object(
+          'cipher', translate(encode(password_cipher, 'base64'), E'\n', ''),
+          'nonce', translate(encode(password_nonce, 'base64'), E'\n', ''))),
+      created_at, updated_at
+    from accounts
+    on conflict (id) do nothing;
+    get diagnostics copied = row_count;
+    select count(*) into total from accounts;
+    if copied <> total then
+      raise exception 'items migration: copied % of % accounts', copied, total;
+    end if;
+    drop table accounts;
+  end if;
+
+  if to_regclass('notes') is not null then
+    insert into items (id, user_id, type, payload, created_at, updated_at)
+    select id, user_id, 'note',
+      jsonb_build_object(
+        'title', jsonb_build_object(
+          'cipher', translate(encode(title_cipher, 'base64'), E'\n', ''),
+          'nonce', translate(encode(title_nonce, 'base64'), E'\n', '')),
+        'text', jsonb_build_object(
+          'cipher', translate(encode(text_cipher, 'base64'), E'\n', ''),
+          'nonce', translate(encode(text_nonce, 'base64'), E'\n', ''))),
+      created_at, updated_at
+    from notes
+    on conflict (id) do nothing;
+    get diagnostics copied = row_count;
+    select count(*) into total from notes;
+    if copied <> total then
+      raise exception 'items migration: copied % of % notes', copied, total;
+    end if;
+    drop table notes;
+  end if;
+end $$;
@@ -0,0 +1,17 @@
+-- Папки с зашифрованным именем и необязательной вложенностью. При удалении папки её записи
+-- и вложенные папки переходят в корень.
+create table if not exists folders (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references users(id) on delete cascade,
+  parent_id uuid references folders(id) on delete set null,
+  name_cipher bytea not null,
+  name_nonce bytea not null,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+
+create index if not exists folders_user_id_idx on folders(user_id);
+
+alter table items add column if not exists folder_id uuid references folders(id) on delete set null;
+
+create index if not exists items_folder_id_idx on items(folder_id);
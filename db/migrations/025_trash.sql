@@ -0,0 +1,4 @@
+-- Корзина: удалённые записи хранятся до очистки (TRASH_RETENTION_DAYS) и могут быть восстановлены.
+alter table items add column if not exists deleted_at timestamptz;
+
+create index if not exists items_deleted_at_idx on items(deleted_at) where deleted_at is not null;
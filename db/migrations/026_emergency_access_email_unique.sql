@@ -0,0 +1,17 @@
+-- Адрес приглашённого сравнивается без учёта регистра, поэтому и уникальность тоже:
+-- иначе Bob@x и bob@x можно было бы пригласить дважды.
+-- Из уже созданных дублей оставляем принятое приглашение, иначе самое раннее.
+delete from emergency_access e
+using emergency_access o
+where e.grantor_id = o.grantor_id
+  and lower(e.grantee_email) = lower(o.grantee_email)
+  and e.id <> o.id
+  and (
+    (e.grantee_id is null and o.grantee_id is not null)
+    or ((e.grantee_id is null) = (o.grantee_id is null) and (e.created_at, e.id) > (o.created_at, o.id))
+  );
+
+alter table emergency_access drop constraint if exists emergency_access_grantor_id_grantee_email_key;
+
+create unique index if not exists emergency_access_grantor_email_idx
+  on emergency_access(grantor_id, lower(grantee_email));
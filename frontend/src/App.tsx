@@ -1,6 +1,7 @@
 import { useEffect, useRef, useState } from "react";
 import type { Session } from "./types";
 import { setApiSessionConfig } from "./api/client";
+import { logoutUser } from "./api/auth";
 import {
   cacheCryptoKey,
   clearCachedCryptoKey,
@@ -54,6 +55,11 @@ export default function App() {
   }, []);
 
   const handleLogout = async () => {
+    const current = sessionRef.current;
+    if (current) {
+      // Сервер отзывает сессию; если он недоступен, выходим локально
+      await logoutUser(current.token, current.refreshToken).catch(() => undefined);
+    }
     await clearStoredSession();
     await clearCachedCryptoKey();
     setSession(null);
@@ -162,7 +168,6 @@ export default function App() {
             onLogout={handleLogout}
             onResetKey={() => setCryptoKey(null)}
             onSessionUpdate={(nextSession) => setSession(nextSession)}
-            onKeyUpdate={(key) => setCryptoKey(key)}
           />
         ) : section === "notes" ? (
           <NotesScreen session={session} cryptoKey={cryptoKey} />
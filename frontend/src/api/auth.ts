@@ -1,12 +1,27 @@
 import { apiRequest } from "./client";
-import type { Session } from "../types";
+import type { KdfParams, Session } from "../types";
+import {
+  DEFAULT_KDF_PARAMS,
+  deriveAuthHash,
+  deriveKey,
+  generateKdfSalt,
+  generateVaultKey,
+  wrapVaultKey
+} from "../crypto/crypto";
 
 type AuthResponse = {
   token: string;
   refreshToken: string;
   email: string;
   kdfSalt: string;
-};
+  vaultKeyCipher?: string;
+  vaultKeyNonce?: string;
+} & KdfParams;
+
+type PreloginResponse = {
+  kdfSalt: string;
+  legacyAuth: boolean;
+} & KdfParams;
 
 type RefreshResponse = {
   token: string;
@@ -15,38 +30,72 @@ type RefreshResponse = {
 
 type ChangePasswordResponse = {
   kdfSalt: string;
+} & KdfParams;
+
+type WrappedVaultKey = {
+  vaultKeyCipher: string;
+  vaultKeyNonce: string;
 };
 
+const toSession = (data: AuthResponse): Session => ({
+  token: data.token,
+  refreshToken: data.refreshToken,
+  email: data.email,
+  kdfSalt: data.kdfSalt,
+  kdfAlgorithm: data.kdfAlgorithm,
+  kdfIterations: data.kdfIterations,
+  kdfMemory: data.kdfMemory,
+  kdfParallelism: data.kdfParallelism,
+  vaultKeyCipher: data.vaultKeyCipher,
+  vaultKeyNonce: data.vaultKeyNonce
+});
+
+export const prelogin = async (email: string): Promise<PreloginResponse> =>
+  apiRequest<PreloginResponse>("/auth/prelogin", {
+    method: "POST",
+    body: { email }
+  });
+
 export const registerUser = async (
   email: string,
   password: string
 ): Promise<Session> => {
+  const kdfSalt = generateKdfSalt();
+  const [authHash, passwordKey, vaultKey] = await Promise.all([
+    deriveAuthHash(password, kdfSalt, DEFAULT_KDF_PARAMS),
+    deriveKey(password, kdfSalt, DEFAULT_KDF_PARAMS),
+    generateVaultKey()
+  ]);
+  const wrapped = await wrapVaultKey(vaultKey, passwordKey);
   const data = await apiRequest<AuthResponse>("/auth/register", {
     method: "POST",
-    body: { email, password }
+    body: {
+      email,
+      authHash,
+      kdfSalt,
+      ...DEFAULT_KDF_PARAMS,
+      vaultKeyCipher: wrapped.cipher,
+      vaultKeyNonce: wrapped.nonce
+    }
   });
-  return {
-    token: data.token,
-    refreshToken: data.refreshToken,
-    email: data.email,
-    kdfSalt: data.kdfSalt
-  };
+  return toSession(data);
 };
 
 export const loginUser = async (
   email: string,
   password: string
 ): Promise<Session> => {
+  const params = await prelogin(email);
+  const authHash = await deriveAuthHash(password, params.kdfSalt, params);
+  // Старые аккаунты один раз присылают пароль, чтобы сервер перевёл их на auth-хэш.
+  const body = params.legacyAuth
+    ? { email, authHash, password }
+    : { email, authHash };
   const data = await apiRequest<AuthResponse>("/auth/login", {
     method: "POST",
-    body: { email, password }
+    body
   });
-  return {
-    token: data.token,
-    refreshToken: data.refreshToken,
-    email: data.email,
-    kdfSalt: data.kdfSalt
-  };
+  return toSession(data);
 };
 
 export const refreshSession = async (
@@ -59,15 +108,50 @@ export const refreshSession = async (
   return { token: data.token, refreshToken: data.refreshToken };
 };
 
+export const logoutUser = async (
+  token: string,
+  refreshToken?: string
+): Promise<void> => {
+  await apiRequest<void>("/auth/logout", {
+    method: "POST",
+    token,
+    body: refreshToken ? { refreshToken } : undefined,
+    _skipRefresh: true
+  });
+};
+
+// Записи зашифрованы ключом хранилища, поэтому при смене пароля перешифровывается только
+// он. У старого хранилища ключом становится текущий ключ из пароля — так оно переходит
+// на ключ хранилища без перешифрования записей.
 export const changeMasterPassword = async (
   token: string,
+  email: string,
   currentPassword: string,
-  newPassword: string
-): Promise<{ kdfSalt: string }> => {
+  newPassword: string,
+  vaultKey: CryptoKey
+): Promise<ChangePasswordResponse & WrappedVaultKey> => {
+  const params = await prelogin(email);
+  const newKdfSalt = generateKdfSalt();
+  const [currentAuthHash, newAuthHash, newPasswordKey] = await Promise.all([
+    deriveAuthHash(currentPassword, params.kdfSalt, params),
+    deriveAuthHash(newPassword, newKdfSalt, DEFAULT_KDF_PARAMS),
+    deriveKey(newPassword, newKdfSalt, DEFAULT_KDF_PARAMS)
+  ]);
+  const wrapped = await wrapVaultKey(vaultKey, newPasswordKey);
+
+  const credentials = params.legacyAuth ? { currentPassword } : { currentAuthHash };
   const data = await apiRequest<ChangePasswordResponse>("/auth/password", {
     method: "POST",
     token,
-    body: { currentPassword, newPassword }
+    body: {
+      ...credentials,
+      newAuthHash,
+      newKdfSalt,
+      newKdfAlgorithm: DEFAULT_KDF_PARAMS.kdfAlgorithm,
+      newKdfIterations: DEFAULT_KDF_PARAMS.kdfIterations,
+      newVaultKeyCipher: wrapped.cipher,
+      newVaultKeyNonce: wrapped.nonce
+    }
   });
-  return { kdfSalt: data.kdfSalt };
+  return { ...data, vaultKeyCipher: wrapped.cipher, vaultKeyNonce: wrapped.nonce };
 };
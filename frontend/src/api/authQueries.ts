@@ -1,8 +1,6 @@
-import { useMutation, useQueryClient } from "@tanstack/react-query";
+import { useMutation } from "@tanstack/react-query";
 import type { Session } from "../types";
-import { deriveKey } from "../crypto/crypto";
 import { setStoredSession } from "../storage";
-import { listAccounts, updateAccount } from "./accounts";
 import { changeMasterPassword, loginUser, registerUser } from "./auth";
 
 type AuthMode = "login" | "register";
@@ -18,42 +16,34 @@ export const useAuthMutation = () =>
 export const useChangeMasterPasswordMutation = ({
   session,
   cryptoKey,
-  onSessionUpdate,
-  onKeyUpdate
+  onSessionUpdate
 }: {
   session: Session;
   cryptoKey: CryptoKey;
   onSessionUpdate: (session: Session) => void;
-  onKeyUpdate: (key: CryptoKey) => void;
-}) => {
-  const queryClient = useQueryClient();
-
-  return useMutation({
+}) =>
+  useMutation({
     mutationFn: async (payload: { currentPassword: string; newPassword: string }) => {
       const result = await changeMasterPassword(
         session.token,
+        session.email,
         payload.currentPassword,
-        payload.newPassword
-      );
-      const newKey = await deriveKey(payload.newPassword, result.kdfSalt);
-      const accounts = await listAccounts(session.token, cryptoKey);
-
-      await Promise.all(
-        accounts.map((account) =>
-          updateAccount(session.token, newKey, account.id, {
-            url: account.url,
-            label: account.label,
-            username: account.username,
-            password: account.password
-          })
-        )
+        payload.newPassword,
+        cryptoKey
       );
 
-      const nextSession = { ...session, kdfSalt: result.kdfSalt };
+      const nextSession: Session = {
+        ...session,
+        kdfSalt: result.kdfSalt,
+        kdfAlgorithm: result.kdfAlgorithm,
+        kdfIterations: result.kdfIterations,
+        kdfMemory: result.kdfMemory,
+        kdfParallelism: result.kdfParallelism,
+        vaultKeyCipher: result.vaultKeyCipher,
+        vaultKeyNonce: result.vaultKeyNonce
+      };
       await setStoredSession(nextSession);
+      // Ключ хранилища не меняется: записи и кэшированный ключ остаются в силе
       onSessionUpdate(nextSession);
-      onKeyUpdate(newKey);
-      await queryClient.invalidateQueries({ queryKey: ["accounts"] });
     }
   });
-};
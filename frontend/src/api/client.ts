@@ -17,6 +17,29 @@ type SessionConfig = {
 
 let sessionConfig: SessionConfig | null = null;
 
+// Один refresh на все параллельные запросы: сервер ротирует refresh-токен, и повторное
+// предъявление старого токена считается его утечкой.
+let refreshInFlight: Promise<Session> | null = null;
+
+const refreshOnce = (session: Session): Promise<Session> => {
+  if (!refreshInFlight) {
+    refreshInFlight = (async () => {
+      const { refreshSession } = await import("./auth");
+      const fresh = await refreshSession(session.refreshToken as string);
+      const newSession: Session = {
+        ...session,
+        token: fresh.token,
+        refreshToken: fresh.refreshToken ?? session.refreshToken
+      };
+      sessionConfig?.setSession(newSession);
+      return newSession;
+    })().finally(() => {
+      refreshInFlight = null;
+    });
+  }
+  return refreshInFlight;
+};
+
 export const setApiSessionConfig = (config: SessionConfig | null): void => {
   sessionConfig = config;
 };
@@ -64,15 +87,8 @@ export const apiRequest = async <T>(
     const session = sessionConfig.getSession();
     if (session?.refreshToken) {
       try {
-        const { refreshSession } = await import("./auth");
-        const fresh = await refreshSession(session.refreshToken);
-        const newSession: Session = {
-          ...session,
-          token: fresh.token,
-          refreshToken: fresh.refreshToken ?? session.refreshToken
-        };
-        sessionConfig.setSession(newSession);
-        response = await tryRequest(fresh.token);
+        const newSession = await refreshOnce(session);
+        response = await tryRequest(newSession.token);
       } catch {
         // refresh не удался, пробрасываем исходную 401
       }
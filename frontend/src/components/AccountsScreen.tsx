@@ -38,7 +38,6 @@ type AccountsScreenProps = {
   onLogout: () => void;
   onResetKey: () => void;
   onSessionUpdate: (session: Session) => void;
-  onKeyUpdate: (key: CryptoKey) => void;
 };
 
 const AccountsScreen = ({
@@ -46,8 +45,7 @@ const AccountsScreen = ({
   cryptoKey,
   onLogout,
   onResetKey,
-  onSessionUpdate,
-  onKeyUpdate
+  onSessionUpdate
 }: AccountsScreenProps) => {
   const [search, setSearch] = useState("");
   const [editing, setEditing] = useState<AccountDecrypted | null>(null);
@@ -68,8 +66,7 @@ const AccountsScreen = ({
   const changePasswordMutation = useChangeMasterPasswordMutation({
     session,
     cryptoKey,
-    onSessionUpdate,
-    onKeyUpdate
+    onSessionUpdate
   });
 
   useEffect(() => {
@@ -2,7 +2,7 @@ import { useState } from "react";
 import type { Session } from "../types";
 import { setStoredSession } from "../storage";
 import { useAuthMutation } from "../api/authQueries";
-import { deriveKey } from "../crypto/crypto";
+import { unlockVault } from "../crypto/crypto";
 
 type AuthMode = "login" | "register";
 
@@ -23,7 +23,7 @@ const AuthScreen = ({ onSuccess }: AuthScreenProps) => {
     try {
       const session = await authMutation.mutateAsync({ mode, email, password });
       await setStoredSession(session);
-      const key = await deriveKey(password, session.kdfSalt);
+      const key = await unlockVault(password, session);
       onSuccess(session, key);
     } catch (err) {
       setError(err instanceof Error ? err.message : "Ошибка авторизации");
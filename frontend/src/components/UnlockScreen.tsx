@@ -1,6 +1,6 @@
 import { useState } from "react";
 import type { Session } from "../types";
-import { deriveKey } from "../crypto/crypto";
+import { unlockVault } from "../crypto/crypto";
 
 type UnlockScreenProps = {
   session: Session;
@@ -18,7 +18,7 @@ const UnlockScreen = ({ session, onUnlock, onLogout }: UnlockScreenProps) => {
     setError(null);
     setSubmitting(true);
     try {
-      const key = await deriveKey(password, session.kdfSalt);
+      const key = await unlockVault(password, session);
       onUnlock(key);
     } catch (err) {
       setError(err instanceof Error ? err.message : "Не удалось разблокировать");
@@ -1,34 +1,145 @@
 import { fromBase64, toBase64 } from "./base64";
+import type { KdfParams, Session } from "../types";
 
 const textEncoder = new TextEncoder();
 const textDecoder = new TextDecoder();
 
-export const deriveKey = async (
+export const DEFAULT_KDF_PARAMS: KdfParams = {
+  kdfAlgorithm: "pbkdf2-sha256",
+  kdfIterations: 100000
+};
+
+const deriveMasterKeyBits = async (
   masterPassword: string,
-  saltBase64: string
-): Promise<CryptoKey> => {
+  saltBase64: string,
+  params: Partial<KdfParams> = DEFAULT_KDF_PARAMS
+): Promise<ArrayBuffer> => {
+  const algorithm = params.kdfAlgorithm ?? DEFAULT_KDF_PARAMS.kdfAlgorithm;
+  if (algorithm !== "pbkdf2-sha256") {
+    throw new Error(`KDF ${algorithm} не поддерживается этой версией расширения`);
+  }
+
   const baseKey = await crypto.subtle.importKey(
     "raw",
     textEncoder.encode(masterPassword),
     { name: "PBKDF2" },
     false,
-    ["deriveKey"]
+    ["deriveBits"]
   );
 
-  return crypto.subtle.deriveKey(
+  return crypto.subtle.deriveBits(
     {
       name: "PBKDF2",
       salt: fromBase64(saltBase64),
-      iterations: 100000,
+      iterations: params.kdfIterations ?? DEFAULT_KDF_PARAMS.kdfIterations,
       hash: "SHA-256"
     },
     baseKey,
-    { name: "AES-GCM", length: 256 },
-    true,
-    ["encrypt", "decrypt"]
+    256
+  );
+};
+
+export const deriveKey = async (
+  masterPassword: string,
+  saltBase64: string,
+  params?: Partial<KdfParams>
+): Promise<CryptoKey> => {
+  const bits = await deriveMasterKeyBits(masterPassword, saltBase64, params);
+  return crypto.subtle.importKey("raw", bits, { name: "AES-GCM" }, true, [
+    "encrypt",
+    "decrypt"
+  ]);
+};
+
+// Ключ хранилища случаен и хранится на сервере зашифрованным ключом из мастер-пароля,
+// поэтому смена пароля перешифровывает только его, а не каждую запись.
+export const generateVaultKey = (): Promise<CryptoKey> =>
+  crypto.subtle.generateKey({ name: "AES-GCM", length: 256 }, true, [
+    "encrypt",
+    "decrypt"
+  ]);
+
+export const wrapVaultKey = async (vaultKey: CryptoKey, passwordKey: CryptoKey) => {
+  const raw = await crypto.subtle.exportKey("raw", vaultKey);
+  const nonce = crypto.getRandomValues(new Uint8Array(12));
+  const ciphertext = await crypto.subtle.encrypt(
+    { name: "AES-GCM", iv: nonce },
+    passwordKey,
+    raw
+  );
+  return {
+    cipher: toBase64(new Uint8Array(ciphertext)),
+    nonce: toBase64(nonce)
+  };
+};
+
+export const unwrapVaultKey = async (
+  cipher: string,
+  nonce: string,
+  passwordKey: CryptoKey
+): Promise<CryptoKey> => {
+  const raw = await crypto.subtle.decrypt(
+    { name: "AES-GCM", iv: fromBase64(nonce) },
+    passwordKey,
+    fromBase64(cipher)
   );
+  return crypto.subtle.importKey("raw", raw, { name: "AES-GCM" }, true, [
+    "encrypt",
+    "decrypt"
+  ]);
 };
 
+// unlockVault возвращает ключ, которым зашифрованы записи. У хранилищ без
+// ключа (созданных до его появления) это сам ключ из мастер-пароля.
+export const unlockVault = async (
+  masterPassword: string,
+  session: Session
+): Promise<CryptoKey> => {
+  const passwordKey = await deriveKey(masterPassword, session.kdfSalt, session);
+  if (!session.vaultKeyCipher || !session.vaultKeyNonce) {
+    return passwordKey;
+  }
+  try {
+    return await unwrapVaultKey(
+      session.vaultKeyCipher,
+      session.vaultKeyNonce,
+      passwordKey
+    );
+  } catch {
+    throw new Error("Неверный мастер-пароль");
+  }
+};
+
+// Auth-хэш — одна итерация PBKDF2 поверх ключа из мастер-пароля с мастер-паролем в
+// качестве соли. Сервер видит только его и не может восстановить ключ.
+export const deriveAuthHash = async (
+  masterPassword: string,
+  saltBase64: string,
+  params?: Partial<KdfParams>
+): Promise<string> => {
+  const masterKey = await crypto.subtle.importKey(
+    "raw",
+    await deriveMasterKeyBits(masterPassword, saltBase64, params),
+    { name: "PBKDF2" },
+    false,
+    ["deriveBits"]
+  );
+  const bits = await crypto.subtle.deriveBits(
+    {
+      name: "PBKDF2",
+      salt: textEncoder.encode(masterPassword),
+      iterations: 1,
+      hash: "SHA-256"
+    },
+    masterKey,
+    256
+  );
+  return toBase64(new Uint8Array(bits));
+};
+
+export const generateKdfSalt = (): string =>
+  toBase64(crypto.getRandomValues(new Uint8Array(16)));
+
 export const encryptField = async (value: string, key: CryptoKey) => {
   const nonce = crypto.getRandomValues(new Uint8Array(12));
   const ciphertext = await crypto.subtle.encrypt(
@@ -1,9 +1,19 @@
+export type KdfParams = {
+  kdfAlgorithm: string;
+  kdfIterations: number;
+  kdfMemory?: number;
+  kdfParallelism?: number;
+};
+
 export type Session = {
   token: string;
   refreshToken?: string; // опционально для обратной совместимости
   email: string;
   kdfSalt: string;
-};
+  // Ключ хранилища, зашифрованный ключом из мастер-пароля; нет у старых хранилищ
+  vaultKeyCipher?: string;
+  vaultKeyNonce?: string;
+} & Partial<KdfParams>; // сессии, сохранённые до появления параметров KDF, их не содержат
 
 export type AccountEncrypted = {
   id: string;